@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+)
+
+// supported values of GRAPH_DB_ID_ALLOCATION
+const ALLOCATION_RANDOM = "random"
+const ALLOCATION_SEQUENTIAL = "sequential"
+const ALLOCATION_HASH = "hash"
+
+// bounds of values handed out to new keys, both inclusive.
+// Set through GRAPH_DB_MIN_VALUE and GRAPH_DB_MAX_VALUE, MIN_VALUE is
+// never negative.
+var MIN_VALUE int64 = 1
+var MAX_VALUE int64 = 999999999 // python max int
+
+// largest value clients limited to 32 bit ints can read
+const MAX_32BIT_VALUE int64 = math.MaxInt32
+
+// number of sequential values leased from badger at a time
+var SEQUENCE_BANDWIDTH uint64 = 1000
+
+// checks whether a value is already assigned to a key
+type valueTakenFunc func(v int64) (bool, error)
+
+// is v within the configured value bounds
+func valueInRange(v int64) bool {
+	return v >= MIN_VALUE && v <= MAX_VALUE
+}
+
+// error for values outside of the configured bounds
+func valueOutOfRangeError(v int64) error {
+	return fmt.Errorf("Value %d is out of range [%d, %d]", v, MIN_VALUE, MAX_VALUE)
+}
+
+// number of values within the configured bounds
+func valueSpan() uint64 {
+	return uint64(MAX_VALUE-MIN_VALUE) + 1
+}
+
+// uniformly random value within the configured bounds
+func randomValue() int64 {
+	return randomValueBetween(MIN_VALUE, MAX_VALUE)
+}
+
+// uniformly random value in [lo, hi], lo is never negative
+func randomValueBetween(lo int64, hi int64) int64 {
+	span := uint64(hi-lo) + 1
+	if span > math.MaxInt64 {
+		// spans the entire non-negative int64 range
+		return lo + rand.Int63()
+	}
+	return lo + rand.Int63n(int64(span))
+}
+
+// picks a value for key k which is not taken yet.
+// nextSequential hands out the next value of the store's sequence.
+func allocateValue(
+	strategy string,
+	k string,
+	nextSequential func() (int64, error),
+	taken valueTakenFunc,
+) (int64, error) {
+	switch strategy {
+	case "", ALLOCATION_RANDOM:
+		return allocateRandomValue(k, taken)
+	case ALLOCATION_SEQUENTIAL:
+		return allocateSequentialValue(k, nextSequential, taken)
+	case ALLOCATION_HASH:
+		return allocateHashValue(k, taken)
+	}
+	return 0, fmt.Errorf("Unknown allocation strategy '%s'", strategy)
+}
+
+// keeps creating random ints until a free one is found
+func allocateRandomValue(k string, taken valueTakenFunc) (int64, error) {
+	v := randomValue()
+	for i := uint64(0); ; i++ {
+		isTaken, err := taken(v)
+		if err != nil {
+			return v, err
+		} else if !isTaken {
+			return v, nil
+		} else if i == valueSpan() {
+			return v, fmt.Errorf("Too many collisions on creating %s", k)
+		}
+		v = randomValue()
+	}
+}
+
+// takes the next value from the sequence, skipping values which were
+// already assigned by another strategy. The sequence starts at MIN_VALUE.
+func allocateSequentialValue(k string, next func() (int64, error), taken valueTakenFunc) (int64, error) {
+	for {
+		n, err := next()
+		if err != nil {
+			return 0, err
+		}
+		v := MIN_VALUE + n
+		if n < 0 || v < MIN_VALUE || v > MAX_VALUE {
+			return v, fmt.Errorf("Value space exhausted on creating %s", k)
+		}
+		isTaken, err := taken(v)
+		if err != nil || !isTaken {
+			return v, err
+		}
+	}
+}
+
+// number of hashes of a key tried before hash allocation gives up
+var MAX_HASH_PROBES = 64
+
+// value derived from the hash of k on the probe-th try. Later tries hash
+// k salted with the number of the try, so the values probed for a key
+// only depend on the key and not on which other keys exist.
+func hashValue(k string, probe int) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(k))
+	if probe > 0 {
+		salt := make([]byte, 8)
+		binary.BigEndian.PutUint64(salt, uint64(probe))
+		h.Write(salt)
+	}
+	return MIN_VALUE + int64(h.Sum64()%valueSpan())
+}
+
+// derives the value from a hash of k, rehashing with a salt while the
+// value is taken. A key gets the same value in any store unless another
+// key took one of its earlier probes.
+func allocateHashValue(k string, taken valueTakenFunc) (int64, error) {
+	for probe := 0; probe < MAX_HASH_PROBES; probe++ {
+		v := hashValue(k, probe)
+		isTaken, err := taken(v)
+		if err != nil || !isTaken {
+			return v, err
+		}
+	}
+	return 0, fmt.Errorf("Too many collisions on creating %s", k)
+}
+
+// number of the try on which hash allocation derives v for k
+func hashProbe(k string, v int64) (int, bool) {
+	for probe := 0; probe < MAX_HASH_PROBES; probe++ {
+		if hashValue(k, probe) == v {
+			return probe, true
+		}
+	}
+	return 0, false
+}
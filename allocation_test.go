@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	badger "github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestAllocateValue(t *testing.T) {
+	var seq int64 = 0
+	next := func() (int64, error) {
+		seq++
+		return seq - 1, nil
+	}
+	taken := map[int64]bool{}
+	isTaken := func(v int64) (bool, error) {
+		return taken[v], nil
+	}
+
+	type Test struct {
+		Name          string
+		Strategy      string
+		ExpectedValue int64
+		ExpectedError string
+		Setup         func()
+		TearDown      func()
+	}
+	testTable := []Test{
+		Test{
+			Name:          "hands out sequential values",
+			Strategy:      "sequential",
+			ExpectedValue: 1,
+			Setup:         func() {},
+			TearDown:      func() {},
+		},
+		Test{
+			Name:          "skips taken sequential values",
+			Strategy:      "sequential",
+			ExpectedValue: 4,
+			Setup: func() {
+				taken[2] = true
+				taken[3] = true
+			},
+			TearDown: func() {},
+		},
+		Test{
+			Name:          "fails once sequence passes MAX_VALUE",
+			Strategy:      "sequential",
+			ExpectedValue: 5,
+			ExpectedError: "Value space exhausted on creating testKey",
+			Setup: func() {
+				MAX_VALUE = 4
+			},
+			TearDown: func() {
+				MAX_VALUE = 999999999
+			},
+		},
+		Test{
+			Name:          "throws error on many random collisions",
+			Strategy:      "random",
+			ExpectedValue: 1,
+			ExpectedError: "Too many collisions on creating testKey",
+			Setup: func() {
+				MAX_VALUE = 1
+				taken[1] = true
+			},
+			TearDown: func() {
+				MAX_VALUE = 999999999
+			},
+		},
+		Test{
+			Name:          "fails on unknown strategy",
+			Strategy:      "guess",
+			ExpectedError: "Unknown allocation strategy 'guess'",
+			Setup:         func() {},
+			TearDown:      func() {},
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			test.Setup()
+			v, err := allocateValue(test.Strategy, "testKey", next, isTaken)
+			assert.Equal(t, test.ExpectedValue, v)
+			if err == nil {
+				assert.Equal(t, test.ExpectedError, "")
+			} else {
+				assert.Equal(t, test.ExpectedError, err.Error())
+			}
+			test.TearDown()
+		})
+	}
+}
+
+func TestSequentialAllocation(t *testing.T) {
+	loadPath := "/tmp/twowaykv/sequential/" + strconv.Itoa(rand.Int())
+	err := os.MkdirAll(loadPath, os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(loadPath)
+	os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+	os.Setenv("GRAPH_DB_ID_ALLOCATION", "sequential")
+	defer os.Unsetenv("GRAPH_DB_ID_ALLOCATION")
+
+	t.Run("badger hands out dense values across restarts", func(t *testing.T) {
+		s, err := NewBadgerStore()
+		require.Nil(t, err)
+		entries, errors := s.CreateIfDoesntExist([]string{"seq1", "seq2", "seq3"}, false)
+		require.Equal(t, []string{}, errors)
+		assert.Equal(t, []Entry{Entry{Key: "seq1", Value: 1}, Entry{Key: "seq2", Value: 2}, Entry{Key: "seq3", Value: 3}}, entries)
+		require.Nil(t, s.Close())
+
+		s, err = NewBadgerStore()
+		require.Nil(t, err)
+		defer s.Close()
+		entries, errors = s.CreateIfDoesntExist([]string{"seq4"}, false)
+		require.Equal(t, []string{}, errors)
+		assert.Equal(t, []Entry{Entry{Key: "seq4", Value: 4}}, entries)
+	})
+
+	t.Run("memory hands out dense values", func(t *testing.T) {
+		s := newTestMemoryStore(t)
+		entries, errors := s.CreateIfDoesntExist([]string{"seq1", "seq2"}, false)
+		require.Equal(t, []string{}, errors)
+		assert.Equal(t, []Entry{Entry{Key: "seq1", Value: 1}, Entry{Key: "seq2", Value: 2}}, entries)
+	})
+}
+
+func TestHashAllocation(t *testing.T) {
+	os.Setenv("GRAPH_DB_ID_ALLOCATION", "hash")
+	defer os.Unsetenv("GRAPH_DB_ID_ALLOCATION")
+	keys := []string{"Barack Obama", "Cheese", "Mozzarella", "Slovakia"}
+
+	t.Run("rebuilding from the same keys yields identical values", func(t *testing.T) {
+		first, errors := newTestMemoryStore(t).CreateIfDoesntExist(keys, false)
+		require.Equal(t, []string{}, errors)
+		second, errors := newTestMemoryStore(t).CreateIfDoesntExist(keys, false)
+		require.Equal(t, []string{}, errors)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("badger and memory agree", func(t *testing.T) {
+		loadPath := "/tmp/twowaykv/hash/" + strconv.Itoa(rand.Int())
+		err := os.MkdirAll(loadPath, os.ModePerm)
+		require.NoError(t, err)
+		defer os.RemoveAll(loadPath)
+		os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+		s, err := NewBadgerStore()
+		require.Nil(t, err)
+		defer s.Close()
+		fromBadger, errors := s.CreateIfDoesntExist(keys, false)
+		require.Equal(t, []string{}, errors)
+		fromMemory, _ := newTestMemoryStore(t).CreateIfDoesntExist(keys, false)
+		assert.Equal(t, fromMemory, fromBadger)
+	})
+
+	t.Run("probes deterministically past taken values", func(t *testing.T) {
+		first, err := allocateHashValue("Cheese", func(v int64) (bool, error) { return false, nil })
+		require.Nil(t, err)
+		assert.Equal(t, hashValue("Cheese", 0), first)
+		// values next to the first probe don't matter
+		taken := map[int64]bool{first: true, hashValue("Cheese", 1): true, first + 1: true}
+		v, err := allocateHashValue("Cheese", func(v int64) (bool, error) { return taken[v], nil })
+		assert.Nil(t, err)
+		assert.Equal(t, hashValue("Cheese", 2), v)
+		probe, ok := hashProbe("Cheese", v)
+		assert.True(t, ok)
+		assert.Equal(t, 2, probe)
+	})
+
+	t.Run("records collisions in v2k", func(t *testing.T) {
+		loadPath := "/tmp/twowaykv/hash/" + strconv.Itoa(rand.Int())
+		err := os.MkdirAll(loadPath, os.ModePerm)
+		require.NoError(t, err)
+		defer os.RemoveAll(loadPath)
+		os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+		s, err := NewBadgerStore()
+		require.Nil(t, err)
+		defer s.Close()
+		_, errors := s.ImportEntries([]Entry{Entry{Key: "Brie", Value: hashValue("Cheese", 0)}})
+		require.Equal(t, []string{}, errors)
+		entries, errors := s.CreateIfDoesntExist([]string{"Cheese", "Mozzarella"}, false)
+		require.Equal(t, []string{}, errors)
+		assert.Equal(t, hashValue("Cheese", 1), entries[0].Value)
+		// probe of a value, 0 if none was recorded
+		probeOf := func(v int64) (probe int64) {
+			s.V2k.View(func(txn *badger.Txn) error {
+				item, err := txn.Get(s.probeKey(v))
+				if err == nil {
+					b, _ := item.ValueCopy(nil)
+					probe, _ = decodeValue(b)
+				}
+				return nil
+			})
+			return probe
+		}
+		assert.Equal(t, int64(1), probeOf(entries[0].Value))
+		assert.Equal(t, int64(0), probeOf(entries[1].Value))
+		_, errors = s.DeleteEntries([]string{"Cheese"}, nil)
+		require.Equal(t, []string{}, errors)
+		assert.Equal(t, int64(0), probeOf(entries[0].Value))
+	})
+
+	t.Run("fails when every value is taken", func(t *testing.T) {
+		MAX_VALUE = 3
+		defer func() { MAX_VALUE = 999999999 }()
+		_, err := allocateHashValue("Cheese", func(v int64) (bool, error) { return true, nil })
+		require.NotNil(t, err)
+		assert.Equal(t, "Too many collisions on creating Cheese", err.Error())
+	})
+}
+
+func TestValueBounds(t *testing.T) {
+	defer func() {
+		MIN_VALUE = 1
+		MAX_VALUE = 999999999
+	}()
+
+	t.Run("random values stay in bounds", func(t *testing.T) {
+		MIN_VALUE = 10
+		MAX_VALUE = 12
+		for i := 0; i < 100; i++ {
+			v := randomValue()
+			assert.True(t, v >= 10 && v <= 12)
+		}
+	})
+	t.Run("supports the full int64 range", func(t *testing.T) {
+		MIN_VALUE = 0
+		MAX_VALUE = math.MaxInt64
+		assert.Equal(t, uint64(1)<<63, valueSpan())
+		assert.True(t, randomValue() >= 0)
+		v, err := allocateHashValue("Cheese", func(v int64) (bool, error) { return false, nil })
+		assert.Nil(t, err)
+		assert.True(t, v >= 0)
+	})
+	t.Run("stores values above 32 bits", func(t *testing.T) {
+		MIN_VALUE = math.MaxInt64 - 10
+		MAX_VALUE = math.MaxInt64
+		s := newTestMemoryStore(t)
+		entries, errors := s.CreateIfDoesntExist([]string{"big1", "big2"}, false)
+		require.Equal(t, []string{}, errors)
+		for _, e := range entries {
+			assert.True(t, e.Value >= math.MaxInt64-10)
+		}
+		found, errors := s.GetEntriesFromValues([]int64{entries[0].Value, entries[1].Value})
+		assert.Equal(t, entries, found)
+		assert.Equal(t, 0, len(errors))
+	})
+	t.Run("rejects lookups of values out of bounds", func(t *testing.T) {
+		MIN_VALUE = 1
+		MAX_VALUE = 100
+		s := newTestMemoryStore(t)
+		s.k2v["tooBig"] = 101
+		s.v2k[101] = "tooBig"
+		found, errors := s.GetEntriesFromValues([]int64{101})
+		assert.Equal(t, 0, len(found))
+		assert.Equal(t, []string{"Could not retrieve entry from value 101: Value 101 is out of range [1, 100]"}, errors)
+		random, err := s.ReadRandomEntries(1, RandomFilter{})
+		assert.Equal(t, 0, len(random))
+		assert.Nil(t, err)
+	})
+	t.Run("warns about stored values outside narrowed bounds", func(t *testing.T) {
+		origLogWarn := logWarn
+		defer func() { logWarn = origLogWarn }()
+		warnings := []string{}
+		logWarn = func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		}
+		loadPath := "/tmp/twowaykv/bounds/" + strconv.Itoa(rand.Int())
+		err := os.MkdirAll(loadPath, os.ModePerm)
+		require.NoError(t, err)
+		defer os.RemoveAll(loadPath)
+		os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+		MIN_VALUE = 1
+		MAX_VALUE = 1000
+		s, err := NewBadgerStore()
+		require.Nil(t, err)
+		_, errors := s.ImportEntries([]Entry{Entry{Key: "small", Value: 5}, Entry{Key: "big", Value: 500}})
+		require.Equal(t, []string{}, errors)
+		s.Close()
+		assert.Equal(t, []string{}, warnings)
+
+		MAX_VALUE = 100
+		s, err = NewBadgerStore()
+		require.Nil(t, err)
+		s.Close()
+		assert.Equal(t, []string{"Store holds values outside of [1, 100], their entries are hidden from /entriesFromValues and /random until GRAPH_DB_MIN_VALUE and GRAPH_DB_MAX_VALUE include them"}, warnings)
+
+		warnings = []string{}
+		MIN_VALUE = 10
+		MAX_VALUE = 1000
+		s, err = NewBadgerStore()
+		require.Nil(t, err)
+		s.Close()
+		assert.Equal(t, 1, len(warnings))
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// default and largest number of entries returned by one page of
+// entries created since a timestamp
+var DEFAULT_CREATED_PAGE_SIZE = 100
+var MAX_CREATED_PAGE_SIZE = 1000
+
+// clock used for creation timestamps
+var now = time.Now
+
+// position in the listing of entries ordered by creation time, then by
+// value. Listings return entries after the position.
+type CreatedCursor struct {
+	Created time.Time
+	Value   int64
+}
+
+// position after every entry created at or before t
+func createdAfter(t time.Time) CreatedCursor {
+	if t.Before(time.Unix(0, 0)) {
+		// timestamps are never before the epoch
+		t = time.Unix(0, 0)
+	}
+	return CreatedCursor{t, math.MaxInt64}
+}
+
+// position of e, which has to have its creation time set
+func createdCursorOf(e Entry) CreatedCursor {
+	return CreatedCursor{*e.Created, e.Value}
+}
+
+// is the position of e after c
+func (c CreatedCursor) precedes(e Entry) bool {
+	if e.Created.Equal(c.Created) {
+		return e.Value > c.Value
+	}
+	return e.Created.After(c.Created)
+}
+
+// fixed width encoding ordered the same way as positions
+func (c CreatedCursor) bytes() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, uint64(c.Created.UnixNano()))
+	binary.BigEndian.PutUint64(b[8:], uint64(c.Value))
+	return b
+}
+
+// opaque form of c handed to clients
+func (c CreatedCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString(c.bytes())
+}
+
+// reads a cursor encoded by bytes
+func createdCursorFromBytes(b []byte) (CreatedCursor, error) {
+	if len(b) != 16 {
+		return CreatedCursor{}, fmt.Errorf("Invalid cursor %q", b)
+	}
+	return CreatedCursor{
+		time.Unix(0, int64(binary.BigEndian.Uint64(b))).UTC(),
+		int64(binary.BigEndian.Uint64(b[8:])),
+	}, nil
+}
+
+// reads a cursor handed to clients by String
+func parseCreatedCursor(s string) (CreatedCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) != 16 {
+		return CreatedCursor{}, fmt.Errorf("Invalid cursor '%s'", s)
+	}
+	return createdCursorFromBytes(b)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCreatedCursor(t *testing.T) {
+	t.Run("round trips through its string form", func(t *testing.T) {
+		c := CreatedCursor{time.Date(2019, 6, 1, 12, 0, 0, 5, time.UTC), 42}
+		parsed, err := parseCreatedCursor(c.String())
+		require.Nil(t, err)
+		assert.True(t, c.Created.Equal(parsed.Created))
+		assert.Equal(t, c.Value, parsed.Value)
+	})
+
+	t.Run("rejects invalid cursors", func(t *testing.T) {
+		_, err := parseCreatedCursor("abc")
+		assert.EqualError(t, err, "Invalid cursor 'abc'")
+	})
+
+	t.Run("orders by time, then by value", func(t *testing.T) {
+		t0 := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+		t1 := t0.Add(time.Nanosecond)
+		c := CreatedCursor{t0, 5}
+		assert.True(t, c.precedes(Entry{Value: 6, Created: &t0}))
+		assert.False(t, c.precedes(Entry{Value: 5, Created: &t0}))
+		assert.True(t, c.precedes(Entry{Value: 1, Created: &t1}))
+		assert.False(t, createdAfter(t0).precedes(Entry{Value: 6, Created: &t0}))
+	})
+}
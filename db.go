@@ -1,15 +1,79 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	badger "github.com/dgraph-io/badger"
+	"math"
 	"math/rand"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 const V2K_PATH = "/v2k"
 const K2V_PATH = "/k2v"
+const DB_PATH = "/db"
+
+// supported values of GRAPH_DB_STORE_LAYOUT
+const LAYOUT_SPLIT = "split"
+const LAYOUT_SINGLE = "single"
+
+// key prefixes of each direction in the single layout
+var K2V_PREFIX = []byte("k/")
+var V2K_PREFIX = []byte("v/")
+
+// prefix of internal bookkeeping keys kept in the v2k DB,
+// sorts after every value
+var META_PREFIX = []byte{0xff}
+var SEQUENCE_KEY = "sequence"
+var FORMAT_KEY = "format"
+var ALIAS_KEY = "alias/"
+var METADATA_KEY = "metadata/"
+var DISPLAY_KEY = "display/"
+
+// number of the hash allocation try which found the value of an entry,
+// only kept for entries whose first try collided
+var PROBE_KEY = "probe/"
+
+// case folded keys for case insensitive search, and the marker of
+// stores whose folded index is complete
+var FOLDED_KEY = "folded/"
+var FOLDED_INDEX_KEY = "index/folded"
+
+// steps of GRAPH_DB_KEY_NORMALIZATION all keys were normalized with
+var NORMALIZATION_KEY = "normalization"
+
+// grams of folded keys for substring search, see trigramsOf, and the
+// marker of stores whose trigram index is complete
+var TRIGRAM_KEY = "trigram/"
+var TRIGRAM_INDEX_KEY = "index/trigram"
+
+// creation time of each value, and the same times ordered by time
+var TIMESTAMP_KEY = "timestamp/"
+var CREATED_KEY = "created/"
+
+// dense index of all values for uniform sampling by /random: the value
+// in each slot, the slot of each value, the number of slots and the
+// marker of stores whose ordinal index is complete
+var ORDINAL_KEY = "ordinal/"
+var SLOT_KEY = "slot/"
+var ORDINAL_COUNT_KEY = "ordinals"
+var ORDINAL_INDEX_KEY = "index/ordinal"
+
+// bookkeeping key of each namespace, and prefix of all keys of a
+// namespace in both DBs
+var NAMESPACE_KEY = "namespace/"
+var NAMESPACE_PREFIX = "ns/"
+
+// marks stores whose values are encoded by encodeValue
+const VALUE_FORMAT_BINARY = "binary"
 
 // connects to both keyToValue and valueToKey store
 func ConnectToDb() (*badger.DB, *badger.DB, error) {
@@ -31,238 +95,2187 @@ func ConnectToDb() (*badger.DB, *badger.DB, error) {
 	return k2v, v2k, err
 }
 
-var INT_MAX = 999999999 // python max int
+// connects to the single DB holding both directions
+func ConnectToSingleDb() (*badger.DB, error) {
+	return badger.Open(badger.DefaultOptions(os.Getenv("GRAPH_DB_STORE_DIR") + DB_PATH))
+}
+
+// Badger backed Store, keeping keys => values and values => keys
+// either in two separate databases or in one database under K2V_PREFIX
+// and V2K_PREFIX
+type BadgerStore struct {
+	K2v *badger.DB
+	V2k *badger.DB
+	// only set in the single layout, where K2v and V2k are the same DB
+	kPrefix []byte
+	vPrefix []byte
+	// serializes writers so that checking a key or value is free and
+	// writing it happen atomically
+	writeLock sync.Mutex
+	// how values of new keys are picked, see allocateValue
+	allocation string
+	// only set when allocation is ALLOCATION_SEQUENTIAL
+	sequence *badger.Sequence
+	// prefix of bookkeeping keys, META_PREFIX unless set
+	mPrefix []byte
+	// name of the namespace a store shares K2v and V2k with its parent in
+	namespace string
+	// rewrites keys before they are stored or looked up
+	normalizer *KeyNormalizer
+	// maintain the trigram index for substring search
+	trigrams bool
+	// opened namespaces by name, guarded by nsLock
+	namespaces map[string]*BadgerStore
+	nsLock     sync.Mutex
+}
+
+// connects to the badger layout selected by GRAPH_DB_STORE_LAYOUT,
+// defaults to the split layout
+func NewBadgerStore() (*BadgerStore, error) {
+	var s *BadgerStore
+	switch os.Getenv("GRAPH_DB_STORE_LAYOUT") {
+	case "", LAYOUT_SPLIT:
+		k2v, v2k, err := ConnectToDb()
+		if err != nil {
+			return nil, err
+		}
+		s = &BadgerStore{K2v: k2v, V2k: v2k}
+	case LAYOUT_SINGLE:
+		db, err := ConnectToSingleDb()
+		if err != nil {
+			return nil, err
+		}
+		s = NewSingleBadgerStore(db)
+	default:
+		return nil, fmt.Errorf("Unknown store layout '%s'", os.Getenv("GRAPH_DB_STORE_LAYOUT"))
+	}
+	normalizer, err := KeyNormalizerFromEnv()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.normalizer = normalizer
+	s.trigrams = os.Getenv("GRAPH_DB_TRIGRAM_INDEX") == "true"
+	if err := s.MigrateValueEncoding(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.BuildIndexes(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.NormalizeKeys(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.SetAllocation(os.Getenv("GRAPH_DB_ID_ALLOCATION")); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.checkValueBounds()
+	return s, nil
+}
+
+// wraps a single DB holding both directions in a store
+func NewSingleBadgerStore(db *badger.DB) *BadgerStore {
+	return &BadgerStore{K2v: db, V2k: db, kPrefix: K2V_PREFIX, vPrefix: V2K_PREFIX}
+}
+
+// sets how values of new keys are picked
+func (s *BadgerStore) SetAllocation(strategy string) (err error) {
+	switch strategy {
+	case "", ALLOCATION_RANDOM, ALLOCATION_HASH:
+	case ALLOCATION_SEQUENTIAL:
+		if s.sequence == nil {
+			s.sequence, err = s.V2k.GetSequence(s.metaKey(SEQUENCE_KEY), SEQUENCE_BANDWIDTH)
+		}
+	default:
+		err = fmt.Errorf("Unknown allocation strategy '%s'", strategy)
+	}
+	if err == nil {
+		s.allocation = strategy
+	}
+	return err
+}
+
+// is the store using the single layout
+func (s *BadgerStore) isSingle() bool {
+	return s.K2v == s.V2k
+}
+
+// closes underlying databases. Namespaces only release their sequence,
+// their databases are closed by their parent.
+func (s *BadgerStore) Close() error {
+	// hand back unused leased values so they aren't skipped on restart
+	if s.sequence != nil {
+		if err := s.sequence.Release(); err != nil {
+			logErr("Error releasing sequence: %v", err)
+		}
+	}
+	if s.namespace != "" {
+		return nil
+	}
+	s.nsLock.Lock()
+	for _, ns := range s.namespaces {
+		ns.Close()
+	}
+	s.nsLock.Unlock()
+	kErr := s.K2v.Close()
+	if s.isSingle() {
+		return kErr
+	}
+	vErr := s.V2k.Close()
+	if kErr != nil {
+		return kErr
+	}
+	return vErr
+}
+
+// k2v DB key of a key
+func (s *BadgerStore) kKey(k string) []byte {
+	return append(append([]byte{}, s.kPrefix...), k...)
+}
+
+// v2k DB key of a value
+func (s *BadgerStore) vKey(v int64) []byte {
+	return append(append([]byte{}, s.vPrefix...), encodeValue(v)...)
+}
+
+// v2k DB key of internal bookkeeping
+func (s *BadgerStore) metaKey(name string) []byte {
+	prefix := s.mPrefix
+	if prefix == nil {
+		prefix = META_PREFIX
+	}
+	return append(append([]byte{}, prefix...), name...)
+}
+
+// key marking alias as an alias of the entry with value v. Aliases of a
+// value are stored next to each other.
+func (s *BadgerStore) aliasKey(v int64, alias string) []byte {
+	return append(s.metaKey(ALIAS_KEY+string(encodeValue(v))), alias...)
+}
+
+// key of the metadata of the entry with value v
+func (s *BadgerStore) metadataKey(v int64) []byte {
+	return s.metaKey(METADATA_KEY + string(encodeValue(v)))
+}
+
+// key of the display form of the key of the entry with value v
+func (s *BadgerStore) displayKey(v int64) []byte {
+	return s.metaKey(DISPLAY_KEY + string(encodeValue(v)))
+}
+
+// display form of key k of the entry with value v in txn, empty if k
+// is an alias or its own display form
+func (s *BadgerStore) displayOf(txn *badger.Txn, k string, v int64) string {
+	if isAlias, _ := s.isAlias(txn, k, v); isAlias {
+		return ""
+	}
+	item, err := txn.Get(s.displayKey(v))
+	if err != nil {
+		return ""
+	}
+	display, _ := item.ValueCopy(nil)
+	return string(display)
+}
+
+// key of the hash allocation try of the entry with value v
+func (s *BadgerStore) probeKey(v int64) []byte {
+	return s.metaKey(PROBE_KEY + string(encodeValue(v)))
+}
+
+// key of key k in the folded index, ordered by the folded form of k
+func (s *BadgerStore) foldedKey(k string) []byte {
+	return append(s.metaKey(FOLDED_KEY+foldKey(k)), append([]byte{0}, k...)...)
+}
+
+// key of key k in the trigram index under gram
+func (s *BadgerStore) trigramKey(gram string, k string) []byte {
+	return append(s.metaKey(TRIGRAM_KEY+gram), append([]byte{0}, k...)...)
+}
+
+// key of the creation time of the entry with value v
+func (s *BadgerStore) timestampKey(v int64) []byte {
+	return s.metaKey(TIMESTAMP_KEY + string(encodeValue(v)))
+}
+
+// key of c in the index of entries by creation time
+func (s *BadgerStore) createdKey(c CreatedCursor) []byte {
+	return append(s.metaKey(CREATED_KEY), c.bytes()...)
+}
+
+// key of the value in slot i of the ordinal index
+func (s *BadgerStore) ordinalKey(i int64) []byte {
+	return s.metaKey(ORDINAL_KEY + string(encodeValue(i)))
+}
+
+// key of the slot of value v in the ordinal index
+func (s *BadgerStore) slotKey(v int64) []byte {
+	return s.metaKey(SLOT_KEY + string(encodeValue(v)))
+}
+
+// is k a key of a namespace rather than an entry of s. Only happens
+// when keys of s are unprefixed.
+func (s *BadgerStore) isNamespaceKey(k []byte) bool {
+	return len(s.kPrefix) == 0 && isMetaKey(k)
+}
+
+// value stored under a v2k DB key
+func (s *BadgerStore) parseVKey(k []byte) (int64, error) {
+	return decodeValue(k[len(s.vPrefix):])
+}
+
+// encodes a value as stored in the k2v DB and v2k keys, fixed width
+// big endian so that v2k is ordered numerically
+func encodeValue(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+// decodes a value stored in the k2v DB or v2k keys
+func decodeValue(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("Invalid value encoding %q", b)
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+// is k a bookkeeping key rather than an entry
+func isMetaKey(k []byte) bool {
+	return bytes.HasPrefix(k, META_PREFIX)
+}
+
+// transactions on the k2v and v2k DBs. In the single layout
+// both are the same transaction, so writes to both directions are atomic.
+type txnPair struct {
+	k2v *badger.Txn
+	v2k *badger.Txn
+	// store the transactions were opened on and entries written so far
+	store    *BadgerStore
+	nWritten int
+	// entries of committed batches and of the current batch
+	results []Entry
+	batch   []batchEntry
+}
+
+// entry reported by a write, and whether it was written in its batch
+type batchEntry struct {
+	Entry
+	written bool
+}
+
+// opens a new pair of transactions
+func (s *BadgerStore) newTxnPair(update bool) *txnPair {
+	if s.isSingle() {
+		txn := s.K2v.NewTransaction(update)
+		return &txnPair{k2v: txn, v2k: txn, store: s, results: []Entry{}}
+	}
+	return &txnPair{k2v: s.K2v.NewTransaction(update), v2k: s.V2k.NewTransaction(update), store: s, results: []Entry{}}
+}
+
+// adds e to the current batch, written tells if e was written in it.
+// Once TXN_BATCH_SIZE entries were written commits them and continues
+// in fresh transactions. Keeps transactions from growing too big on
+// large requests.
+func (t *txnPair) checkpoint(e Entry, written bool) (errors []string) {
+	t.batch = append(t.batch, batchEntry{e, written})
+	if !written {
+		return nil
+	}
+	if t.nWritten++; t.nWritten%TXN_BATCH_SIZE != 0 {
+		return nil
+	}
+	errors = t.commitBatch()
+	t.Discard()
+	fresh := t.store.newTxnPair(true)
+	t.k2v, t.v2k = fresh.k2v, fresh.v2k
+	return errors
+}
+
+// commits the current batch. Its entries are added to the results if
+// that succeeds, otherwise the entries written in it are dropped and
+// reported as errors.
+func (t *txnPair) commitBatch() (errors []string) {
+	err := t.Commit()
+	if err != nil {
+		logErr("Error committing entries: %v", err)
+	}
+	for _, b := range t.batch {
+		if err != nil && b.written {
+			errors = append(errors, fmt.Sprintf("Could not write entry %s: %s", b.Key, err.Error()))
+		} else {
+			t.results = append(t.results, b.Entry)
+		}
+	}
+	t.batch = nil
+	return errors
+}
+
+// commits v2k first so that keys are never visible without their value
+func (t *txnPair) Commit() error {
+	if err := t.v2k.Commit(); err != nil {
+		return err
+	}
+	if t.k2v == t.v2k {
+		return nil
+	}
+	return t.k2v.Commit()
+}
+
+func (t *txnPair) Discard() {
+	t.k2v.Discard()
+	t.v2k.Discard()
+}
+
+// runs fn in read only transactions on both directions
+func (s *BadgerStore) view(fn func(t *txnPair) error) error {
+	t := s.newTxnPair(false)
+	defer t.Discard()
+	return fn(t)
+}
+
+// max number of entries written per transaction
+var TXN_BATCH_SIZE = 1000
 
 // creates new Entry object to be written
 // assumed that key is not duplicate
-func GenerateEntry(v2k *badger.DB, k string) (Entry, error) {
-	v := rand.Intn(INT_MAX)
-	val := []byte(strconv.Itoa(v))
-	// assert that keys and values do not already exist
-	err := v2k.View(func(txn *badger.Txn) error {
-		// keep creating random ints until is found
-		keyIsUnique := false
-		i := 0
-		for !keyIsUnique {
-			_, err := txn.Get(val)
-			// key not found, stopping condition
-			if err != nil && err == badger.ErrKeyNotFound {
-				keyIsUnique = true
-			} else if err != nil {
-				// normal error
-				return err
-			} else if i == INT_MAX {
-				return fmt.Errorf("Too many collisions on creating %s", k)
-			}
-			// key is found somewhere without error, find a new one
-			i++
-			v = rand.Intn(INT_MAX)
-			val = []byte(strconv.Itoa(v))
+func (s *BadgerStore) GenerateEntry(k string) (e Entry, err error) {
+	err = s.view(func(t *txnPair) error {
+		e, err = s.generateEntry(t.v2k, k)
+		return err
+	})
+	return e, err
+}
+
+// creates new Entry with a value not yet in txn
+func (s *BadgerStore) generateEntry(txn *badger.Txn, k string) (Entry, error) {
+	v, err := allocateValue(s.allocation, k, s.nextSequential, func(v int64) (bool, error) {
+		_, err := txn.Get(s.vKey(v))
+		if err == badger.ErrKeyNotFound {
+			return false, nil
 		}
-		return nil
+		return err == nil, err
 	})
-	return Entry{k, v}, err
+	return Entry{Key: k, Value: v}, err
+}
+
+// next value of the persisted sequence, starting at 0
+func (s *BadgerStore) nextSequential() (int64, error) {
+	if s.sequence == nil {
+		return 0, fmt.Errorf("Sequential allocation is not enabled")
+	}
+	v, err := s.sequence.Next()
+	return int64(v), err
 }
 
 // adds new entry to DB if doesnt already exist
 // MuteAlreadyExists does not add errors to list if key already exists
-func CreateIfDoesntExist(
+func (s *BadgerStore) CreateIfDoesntExist(
+	keys []string,
+	muteAlreadyExists bool,
+) (
+	entries []Entry,
+	errors []string,
+) {
+	return s.CreateWithTTL(keys, muteAlreadyExists, 0)
+}
+
+// same as CreateIfDoesntExist, new entries expire after ttl unless ttl
+// is 0. Existing entries keep their expiry.
+func (s *BadgerStore) CreateWithTTL(
 	keys []string,
 	muteAlreadyExists bool,
-	k2v *badger.DB,
-	v2k *badger.DB,
+	ttl time.Duration,
 ) (
 	entries []Entry,
 	errors []string,
 ) {
 	// initialize return variables
-	entries = []Entry{}
 	errors = []string{}
-	keysToWriteToDB := []string{}
-	// find entries to create
-	k2v.View(func(txn *badger.Txn) error {
-		for _, k := range keys {
-			// expect KEY_NOT_FOUND error
-			item, err := txn.Get([]byte(k))
-			if err == badger.ErrKeyNotFound {
-				keysToWriteToDB = append(keysToWriteToDB, k)
-			} else if err == nil {
-				// key already exists in DB
-				if !muteAlreadyExists {
-					errors = append(errors, fmt.Sprintf("Key %s already exists in DB", k))
-				}
-				// add to response
-				key := string(item.KeyCopy(nil))
-				v, _ := item.ValueCopy(nil)
-				val, _ := strconv.Atoi(string(v))
-				entries = append(entries, Entry{key, val})
-			} else if err != nil {
-				// io error on lookup
-				logErr("Error on looking up key %s: %v", k, err)
-				errors = append(errors, err.Error())
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	t := s.newTxnPair(true)
+	defer t.Discard()
+	for _, original := range keys {
+		k := s.normalizer.Normalize(original)
+		if err := checkKeys(k); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		// expect KEY_NOT_FOUND error
+		item, err := t.k2v.Get(s.kKey(k))
+		if err == nil {
+			// key already exists in DB
+			if !muteAlreadyExists {
+				errors = append(errors, fmt.Sprintf("Key %s already exists in DB", k))
 			}
+			// add to response
+			v, _ := item.ValueCopy(nil)
+			val, _ := decodeValue(v)
+			t.checkpoint(Entry{Key: k, Value: val, Display: s.displayOf(t.v2k, k, val)}, false)
+			continue
+		} else if err != badger.ErrKeyNotFound {
+			// io error on lookup
+			logErr("Error on looking up key %s: %v", k, err)
+			errors = append(errors, err.Error())
+			continue
 		}
-		return nil
-	})
-
-	// batch write keys
-	k2vWB := k2v.NewWriteBatch()
-	v2kWB := v2k.NewWriteBatch()
-	defer k2vWB.Cancel()
-	defer v2kWB.Cancel()
-	// write entries to both DBs
-	for _, k := range keysToWriteToDB {
-		e, err := writeEntryToDB(v2k, k2vWB, v2kWB, k)
+		e, err := s.writeEntryToDB(t, k, s.normalizer.Display(original, k), expiresAt(ttl))
 		if err != nil {
 			logErr("Could not create entry %+v: %v", e, err)
-		} else {
-			entries = append(entries, e)
+			errors = append(errors, err.Error())
+			continue
 		}
+		errors = append(errors, t.checkpoint(e, true)...)
 	}
-	// flush transactions
-	if err := v2kWB.Flush(); err != nil {
-		logErr("Error flushing v2k Write Batch %v", err)
-		errors = append(errors, err.Error())
+	// commit transactions
+	errors = append(errors, t.commitBatch()...)
+	return t.results, errors
+}
+
+// adds entries with values chosen by the client. Entries whose key or
+// value is already taken are not written and reported as errors.
+func (s *BadgerStore) ImportEntries(toImport []Entry) (entries []Entry, errors []string) {
+	errors = []string{}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	t := s.newTxnPair(true)
+	defer t.Discard()
+	for _, e := range toImport {
+		original := e.Key
+		e.Key = s.normalizer.Normalize(original)
+		e.Display = s.normalizer.Display(original, e.Key)
+		if err := s.checkImport(t, e); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		m, err := checkMetadata(e.Key, e.Metadata)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		e.Metadata = m
+		err = s.setEntryInDB(t, e, 0)
+		if err == nil {
+			err = s.setCreatedInDB(t, e.Value, now(), 0)
+		}
+		if err == nil {
+			err = s.addOrdinalInDB(t.v2k, e.Value)
+		}
+		if err != nil {
+			logErr("Could not import entry %+v: %v", e, err)
+			errors = append(errors, err.Error())
+			continue
+		}
+		errors = append(errors, t.checkpoint(e, true)...)
 	}
-	if err := k2vWB.Flush(); err != nil {
-		logErr("Error flushing k2v Write Batch %v", err)
-		errors = append(errors, err.Error())
+	errors = append(errors, t.commitBatch()...)
+	return t.results, errors
+}
+
+// checks that neither side of e is taken in t
+func (s *BadgerStore) checkImport(t *txnPair, e Entry) error {
+	if err := checkKeys(e.Key); err != nil {
+		return err
 	}
-	return entries, errors
+	if !valueInRange(e.Value) {
+		return valueOutOfRangeError(e.Value)
+	}
+	if _, err := t.k2v.Get(s.kKey(e.Key)); err == nil {
+		return fmt.Errorf("Key %s already exists in DB", e.Key)
+	} else if err != badger.ErrKeyNotFound {
+		logErr("Error on looking up key %s: %v", e.Key, err)
+		return err
+	}
+	if _, err := t.v2k.Get(s.vKey(e.Value)); err == nil {
+		return fmt.Errorf("Value %d already exists in DB", e.Value)
+	} else if err != badger.ErrKeyNotFound {
+		logErr("Error on looking up value %d: %v", e.Value, err)
+		return err
+	}
+	return nil
+}
+
+// expiry of entries written now with ttl, in badger's unix seconds.
+// No expiry for ttl 0.
+func expiresAt(ttl time.Duration) uint64 {
+	if ttl == 0 {
+		return 0
+	}
+	return uint64(now().Add(ttl).Unix())
+}
+
+// sets k to v in txn, expiring at expiresAt unless it is 0
+func setWithExpiry(txn *badger.Txn, k []byte, v []byte, expiresAt uint64) error {
+	return txn.SetEntry(&badger.Entry{Key: k, Value: v, ExpiresAt: expiresAt})
 }
 
-// creates and writes a new entry to DB in batch mode
-func writeEntryToDB(
-	v2k *badger.DB,
-	kv2WB *badger.WriteBatch,
-	v2kWB *badger.WriteBatch,
-	key string,
-) (e Entry, err error) {
+// creates and writes a new entry to both directions of t
+func (s *BadgerStore) writeEntryToDB(t *txnPair, key string, display string, expiresAt uint64) (e Entry, err error) {
 	// create new
-	e, err = GenerateEntry(v2k, key)
+	e, err = s.generateEntry(t.v2k, key)
 	if err != nil {
 		logErr("Error generating entry %s: %v", key, err)
 		return Entry{}, err
 	}
+	e.Display = display
 	// write to DB
-	v := []byte(strconv.Itoa(e.Value))
-	k := []byte(e.Key)
-	if err = kv2WB.Set(k, v); err != nil {
-		logErr("Error setting k2v %+v: %v", e, err)
+	if err = s.setEntryInDB(t, e, expiresAt); err != nil {
 		return Entry{}, err
 	}
-	if err = v2kWB.Set(v, k); err != nil {
-		logErr("Error setting v2k %+v: %v", e, err)
+	if err = s.setCreatedInDB(t, e.Value, now(), expiresAt); err != nil {
+		logErr("Error setting creation time %+v: %v", e, err)
+		return Entry{}, err
 	}
-	return e, err
+	if err = s.addOrdinalInDB(t.v2k, e.Value); err != nil {
+		logErr("Error adding %+v to the ordinal index: %v", e, err)
+		return Entry{}, err
+	}
+	if s.allocation != ALLOCATION_HASH {
+		return e, nil
+	}
+	// record collisions of hash allocation
+	if probe, ok := hashProbe(key, e.Value); ok && probe > 0 {
+		if err = setWithExpiry(t.v2k, s.probeKey(e.Value), encodeValue(int64(probe)), expiresAt); err != nil {
+			return Entry{}, err
+		}
+	}
+	return e, nil
 }
 
-// reads a number of random entries from DB
-func readRandomEntries(
-	v2k *badger.DB,
-	n int,
-) (
-	entries []Entry,
-	err error,
-) {
-	// open up DB read
-	err = v2k.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchSize = n
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		// keep track of tries
-		m := make(map[int]bool)
-		maxRetries := n * 5
-		tries := 0
-		// loop through different random numbers and seek at that n
-		for prefix := rand.Intn(INT_MAX); len(entries) < n; prefix = rand.Intn(INT_MAX) {
-			// start iterator at random N
-			it.Seek([]byte(strconv.Itoa(prefix)))
-			if it.Valid() {
-				k, _ := strconv.Atoi(string(it.Item().Key()))
-				// prefix found, make sure id is't 0
-				if k != 0 && !m[k] {
-					it.Item().Value(func(v []byte) error {
-						// add to entries
-						entries = append(entries, Entry{string(v), k})
-						m[k] = true
-						return nil
-					})
-				}
-			}
-			// could not find key, incr tries
-			tries++
-			// return error if too many retries
-			if tries > maxRetries {
-				return fmt.Errorf("max collisions reached finding random entries")
-			}
-		}
-		// exit db.view
-		return nil
-	})
-	return entries, err
+// records that the entry with value v was created at created in t,
+// expiring with the entry
+func (s *BadgerStore) setCreatedInDB(t *txnPair, v int64, created time.Time, expiresAt uint64) error {
+	ts := encodeValue(created.UnixNano())
+	if err := setWithExpiry(t.v2k, s.timestampKey(v), ts, expiresAt); err != nil {
+		return err
+	}
+	return setWithExpiry(t.v2k, s.createdKey(CreatedCursor{created, v}), []byte{}, expiresAt)
 }
 
-// retrieves entries from k2v DB
-func GetEntriesFromKeys(k2v *badger.DB, keys []string) (entries []Entry, errors []string) {
-	k2v.View(func(txn *badger.Txn) error {
-		for _, k := range keys {
-			item, err := txn.Get([]byte(k))
-			if err != nil {
-				errors = append(errors, fmt.Sprintf("Could not retrieve entry from key %s: %s", k, err.Error()))
-			} else {
-				// add to response
-				key := string(item.KeyCopy(nil))
-				v, _ := item.ValueCopy(nil)
-				val, _ := strconv.Atoi(string(v))
-				entries = append(entries, Entry{key, val})
-			}
-		}
+// removes the creation time of the entry with value v from t, if any
+func (s *BadgerStore) deleteCreatedFromDB(t *txnPair, v int64) error {
+	item, err := t.v2k.Get(s.timestampKey(v))
+	if err == badger.ErrKeyNotFound {
+		// created before creation times were recorded
 		return nil
-	})
-	return entries, errors
+	} else if err != nil {
+		return err
+	}
+	ts, _ := item.ValueCopy(nil)
+	created, err := decodeValue(ts)
+	if err != nil {
+		return err
+	}
+	if err := t.v2k.Delete(s.createdKey(CreatedCursor{time.Unix(0, created), v})); err != nil {
+		return err
+	}
+	return t.v2k.Delete(s.timestampKey(v))
 }
 
-func GetEntriesFromValues(v2k *badger.DB, values []int) (entries []Entry, errors []string) {
-	v2k.View(func(txn *badger.Txn) error {
-		for _, v := range values {
-			item, err := txn.Get([]byte(strconv.Itoa(v)))
-			if err != nil {
-				errors = append(errors, fmt.Sprintf("Could not retrieve entry from value %d: %s", v, err.Error()))
-			} else {
-				// add to response
-				v := string(item.KeyCopy(nil))
-				val, _ := strconv.Atoi(v)
-				key, _ := item.ValueCopy(nil)
-				entries = append(entries, Entry{string(key), val})
-			}
+// adds value v to the ordinal index in txn. Keeps the slot of an
+// expired entry with the same value rather than adding v twice.
+func (s *BadgerStore) addOrdinalInDB(txn *badger.Txn, v int64) error {
+	if _, found, err := readEncoded(txn, s.slotKey(v)); err != nil || found {
+		return err
+	}
+	n, _, err := readEncoded(txn, s.metaKey(ORDINAL_COUNT_KEY))
+	if err != nil {
+		return err
+	}
+	if err := txn.Set(s.ordinalKey(n), encodeValue(v)); err != nil {
+		return err
+	}
+	if err := txn.Set(s.slotKey(v), encodeValue(n)); err != nil {
+		return err
+	}
+	return txn.Set(s.metaKey(ORDINAL_COUNT_KEY), encodeValue(n+1))
+}
+
+// removes value v from the ordinal index in txn, if it is in it, by
+// moving the value of the last slot into its slot
+func (s *BadgerStore) deleteOrdinalFromDB(txn *badger.Txn, v int64) error {
+	slot, found, err := readEncoded(txn, s.slotKey(v))
+	if err != nil || !found {
+		return err
+	}
+	n, _, err := readEncoded(txn, s.metaKey(ORDINAL_COUNT_KEY))
+	if err != nil {
+		return err
+	}
+	last := n - 1
+	if slot != last {
+		moved, _, err := readEncoded(txn, s.ordinalKey(last))
+		if err != nil {
+			return err
 		}
-		return nil
-	})
-	return entries, errors
+		if err := txn.Set(s.ordinalKey(slot), encodeValue(moved)); err != nil {
+			return err
+		}
+		if err := txn.Set(s.slotKey(moved), encodeValue(slot)); err != nil {
+			return err
+		}
+	}
+	if err := txn.Delete(s.ordinalKey(last)); err != nil {
+		return err
+	}
+	if err := txn.Delete(s.slotKey(v)); err != nil {
+		return err
+	}
+	return txn.Set(s.metaKey(ORDINAL_COUNT_KEY), encodeValue(last))
 }
 
-var MAX_QUERY_RESULTS = 25
+// decodes the value stored under k in txn, found is false if k isn't set
+func readEncoded(txn *badger.Txn, k []byte) (v int64, found bool, err error) {
+	item, err := txn.Get(k)
+	if err == badger.ErrKeyNotFound {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	b, err := item.ValueCopy(nil)
+	if err != nil {
+		return 0, false, err
+	}
+	v, err = decodeValue(b)
+	return v, err == nil, err
+}
 
-func SeekWithPrefix(k2v *badger.DB, q string) (entries []Entry, errors []string) {
-	k2v.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte(q)
-		nFound := 0
-		for it.Seek(prefix); it.ValidForPrefix(prefix) && nFound < MAX_QUERY_RESULTS; it.Next() {
-			item := it.Item()
-			// add to response
-			key := string(item.KeyCopy(nil))
-			v, _ := item.ValueCopy(nil)
-			val, _ := strconv.Atoi(string(v))
-			entries = append(entries, Entry{key, val})
-			nFound++
+// writes both directions, the metadata and display form of an entry in
+// t, expiring at
+// expiresAt unless it is 0
+func (s *BadgerStore) setEntryInDB(t *txnPair, e Entry, expiresAt uint64) (err error) {
+	if err = setWithExpiry(t.v2k, s.vKey(e.Value), []byte(e.Key), expiresAt); err != nil {
+		logErr("Error setting v2k %+v: %v", e, err)
+		return err
+	}
+	if e.Metadata != nil {
+		if err = setWithExpiry(t.v2k, s.metadataKey(e.Value), e.Metadata, expiresAt); err != nil {
+			logErr("Error setting metadata %+v: %v", e, err)
+			return err
 		}
-		return nil
+	}
+	if e.Display != "" {
+		if err = setWithExpiry(t.v2k, s.displayKey(e.Value), []byte(e.Display), expiresAt); err != nil {
+			logErr("Error setting display form %+v: %v", e, err)
+			return err
+		}
+	}
+	if err = s.setKeyInDB(t, e.Key, e.Value, expiresAt); err != nil {
+		logErr("Error setting k2v %+v: %v", e, err)
+	}
+	return err
+}
+
+// removes entries by key or by value from both directions
+func (s *BadgerStore) DeleteEntries(keys []string, values []int64) (entries []Entry, errors []string) {
+	errors = []string{}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	t := s.newTxnPair(true)
+	defer t.Discard()
+	// entries can be passed both by key and by value
+	deleted := map[int64]bool{}
+	deleteEntry := func(e Entry) {
+		if err := s.deleteEntryFromDB(t, e); err != nil {
+			logErr("Could not delete entry %+v: %v", e, err)
+			errors = append(errors, err.Error())
+			return
+		}
+		deleted[e.Value] = true
+		errors = append(errors, t.checkpoint(e, true)...)
+	}
+	for _, k := range s.normalizer.NormalizeAll(keys) {
+		if err := checkKeys(k); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		item, err := t.k2v.Get(s.kKey(k))
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Could not delete entry from key %s: %s", k, err.Error()))
+			continue
+		}
+		v, _ := item.ValueCopy(nil)
+		val, _ := decodeValue(v)
+		if deleted[val] {
+			continue
+		}
+		// aliases delete the entry they resolve to
+		item, err = t.v2k.Get(s.vKey(val))
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Could not delete entry from key %s: %s", k, err.Error()))
+			continue
+		}
+		key, _ := item.ValueCopy(nil)
+		deleteEntry(Entry{Key: string(key), Value: val})
+	}
+	for _, v := range values {
+		if deleted[v] {
+			continue
+		}
+		item, err := t.v2k.Get(s.vKey(v))
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Could not delete entry from value %d: %s", v, err.Error()))
+			continue
+		}
+		key, _ := item.ValueCopy(nil)
+		deleteEntry(Entry{Key: string(key), Value: v})
+	}
+	errors = append(errors, t.commitBatch()...)
+	return t.results, errors
+}
+
+// deletes both directions of an entry, its metadata, display form,
+// creation time, slot in the ordinal index and all of its aliases in t
+func (s *BadgerStore) deleteEntryFromDB(t *txnPair, e Entry) error {
+	for _, alias := range s.aliasesOf(t.v2k, e.Value) {
+		if err := s.deleteAliasFromDB(t, alias, e.Value); err != nil {
+			return err
+		}
+	}
+	if err := t.v2k.Delete(s.metadataKey(e.Value)); err != nil {
+		return err
+	}
+	if err := t.v2k.Delete(s.displayKey(e.Value)); err != nil {
+		return err
+	}
+	if err := t.v2k.Delete(s.probeKey(e.Value)); err != nil {
+		return err
+	}
+	if err := s.deleteCreatedFromDB(t, e.Value); err != nil {
+		return err
+	}
+	if err := s.deleteOrdinalFromDB(t.v2k, e.Value); err != nil {
+		return err
+	}
+	if err := t.v2k.Delete(s.vKey(e.Value)); err != nil {
+		return err
+	}
+	return s.deleteKeyFromDB(t, e.Key)
+}
+
+// moves values from one key to another. Fails for renames onto keys
+// which already exist.
+func (s *BadgerStore) RenameEntries(renames []Rename) (entries []Entry, errors []string) {
+	errors = []string{}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	t := s.newTxnPair(true)
+	defer t.Discard()
+	for _, r := range renames {
+		to := r.To
+		r = Rename{s.normalizer.Normalize(r.From), s.normalizer.Normalize(r.To)}
+		if err := checkKeys(r.From, r.To); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		e, err := s.renameKeyInDB(t, r, s.normalizer.Display(to, r.To))
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		errors = append(errors, t.checkpoint(e, true)...)
+	}
+	errors = append(errors, t.commitBatch()...)
+	return t.results, errors
+}
+
+// moves the value of key r.From to key r.To in t, giving the entry the
+// display form display. Aliases stay aliases of the same entry.
+func (s *BadgerStore) renameKeyInDB(t *txnPair, r Rename, display string) (Entry, error) {
+	item, err := t.k2v.Get(s.kKey(r.From))
+	if err != nil {
+		return Entry{}, fmt.Errorf("Could not rename key %s: %s", r.From, err.Error())
+	}
+	v, _ := item.ValueCopy(nil)
+	val, _ := decodeValue(v)
+	if _, err := t.k2v.Get(s.kKey(r.To)); err == nil {
+		return Entry{}, fmt.Errorf("Could not rename key %s: Key %s already exists in DB", r.From, r.To)
+	} else if err != badger.ErrKeyNotFound {
+		return Entry{}, fmt.Errorf("Could not rename key %s: %s", r.From, err.Error())
+	}
+	e := Entry{Key: r.To, Value: val, Display: display}
+	isAlias, err := s.isAlias(t.v2k, r.From, val)
+	if err != nil {
+		return Entry{}, fmt.Errorf("Could not rename key %s: %s", r.From, err.Error())
+	}
+	if isAlias {
+		// aliases stay aliases of the same entry
+		err = s.deleteAliasFromDB(t, r.From, val)
+		if err == nil {
+			err = s.setAliasInDB(t, r.To, val, item.ExpiresAt())
+		}
+	} else {
+		err = s.renameEntryInDB(t, Entry{Key: r.From, Value: val}, e, item.ExpiresAt())
+	}
+	if err != nil {
+		logErr("Could not rename entry %+v: %v", r, err)
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// replaces entry from with entry to, keeping its value and expiry, in t
+func (s *BadgerStore) renameEntryInDB(t *txnPair, from Entry, to Entry, expiresAt uint64) error {
+	if err := s.deleteKeyFromDB(t, from.Key); err != nil {
+		return err
+	}
+	if err := t.v2k.Delete(s.displayKey(from.Value)); err != nil {
+		return err
+	}
+	// the value was not derived from the new key
+	if err := t.v2k.Delete(s.probeKey(from.Value)); err != nil {
+		return err
+	}
+	return s.setEntryInDB(t, to, expiresAt)
+}
+
+// replaces the metadata of the entries of keys. Aliases update the
+// metadata of the entry they resolve to.
+func (s *BadgerStore) UpdateMetadata(updates []MetadataUpdate) (entries []Entry, errors []string) {
+	errors = []string{}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	t := s.newTxnPair(true)
+	defer t.Discard()
+	for _, u := range updates {
+		u.Key = s.normalizer.Normalize(u.Key)
+		if err := checkKeys(u.Key); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		m, err := checkMetadata(u.Key, u.Metadata)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		item, err := t.k2v.Get(s.kKey(u.Key))
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Could not update metadata of key %s: %s", u.Key, err.Error()))
+			continue
+		}
+		v, _ := item.ValueCopy(nil)
+		val, _ := decodeValue(v)
+		if m == nil {
+			err = t.v2k.Delete(s.metadataKey(val))
+		} else {
+			err = setWithExpiry(t.v2k, s.metadataKey(val), m, item.ExpiresAt())
+		}
+		if err != nil {
+			logErr("Could not update metadata of key %s: %v", u.Key, err)
+			errors = append(errors, err.Error())
+			continue
+		}
+		errors = append(errors, t.checkpoint(Entry{Key: u.Key, Value: val, Metadata: m}, true)...)
+	}
+	errors = append(errors, t.commitBatch()...)
+	return t.results, errors
+}
+
+// sets the metadata of entries from the DB, entries without metadata
+// are left without
+func (s *BadgerStore) WithMetadata(entries []Entry) ([]Entry, []string) {
+	errors := []string{}
+	s.V2k.View(func(txn *badger.Txn) error {
+		for i, e := range entries {
+			item, err := txn.Get(s.metadataKey(e.Value))
+			if err == badger.ErrKeyNotFound {
+				continue
+			} else if err != nil {
+				errors = append(errors, fmt.Sprintf("Could not retrieve metadata of key %s: %s", e.Key, err.Error()))
+				continue
+			}
+			m, _ := item.ValueCopy(nil)
+			entries[i].Metadata = json.RawMessage(m)
+		}
+		return nil
+	})
+	return entries, errors
+}
+
+// adds aliases resolving to the values of existing keys. Aliases can
+// be added to keys which are aliases themselves.
+func (s *BadgerStore) AddAliases(aliases []Alias) (entries []Entry, errors []string) {
+	errors = []string{}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	t := s.newTxnPair(true)
+	defer t.Discard()
+	for _, a := range aliases {
+		a = Alias{s.normalizer.Normalize(a.Alias), s.normalizer.Normalize(a.Key)}
+		if err := checkKeys(a.Alias, a.Key); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		item, err := t.k2v.Get(s.kKey(a.Key))
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Could not add alias %s: %s", a.Alias, err.Error()))
+			continue
+		}
+		if _, err := t.k2v.Get(s.kKey(a.Alias)); err == nil {
+			errors = append(errors, fmt.Sprintf("Could not add alias %s: Key %s already exists in DB", a.Alias, a.Alias))
+			continue
+		} else if err != badger.ErrKeyNotFound {
+			errors = append(errors, fmt.Sprintf("Could not add alias %s: %s", a.Alias, err.Error()))
+			continue
+		}
+		v, _ := item.ValueCopy(nil)
+		val, _ := decodeValue(v)
+		if err := s.setAliasInDB(t, a.Alias, val, item.ExpiresAt()); err != nil {
+			logErr("Could not add alias %+v: %v", a, err)
+			errors = append(errors, err.Error())
+			continue
+		}
+		errors = append(errors, t.checkpoint(Entry{Key: a.Alias, Value: val}, true)...)
+	}
+	errors = append(errors, t.commitBatch()...)
+	return t.results, errors
+}
+
+// removes aliases, the entries they resolved to are kept
+func (s *BadgerStore) RemoveAliases(aliases []string) (entries []Entry, errors []string) {
+	errors = []string{}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	t := s.newTxnPair(true)
+	defer t.Discard()
+	for _, alias := range s.normalizer.NormalizeAll(aliases) {
+		if err := checkKeys(alias); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		item, err := t.k2v.Get(s.kKey(alias))
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Could not remove alias %s: %s", alias, err.Error()))
+			continue
+		}
+		v, _ := item.ValueCopy(nil)
+		val, _ := decodeValue(v)
+		if isAlias, err := s.isAlias(t.v2k, alias, val); err != nil {
+			errors = append(errors, fmt.Sprintf("Could not remove alias %s: %s", alias, err.Error()))
+			continue
+		} else if !isAlias {
+			errors = append(errors, fmt.Sprintf("Could not remove alias %s: Key %s is not an alias", alias, alias))
+			continue
+		}
+		if err := s.deleteAliasFromDB(t, alias, val); err != nil {
+			logErr("Could not remove alias %s: %v", alias, err)
+			errors = append(errors, err.Error())
+			continue
+		}
+		errors = append(errors, t.checkpoint(Entry{Key: alias, Value: val}, true)...)
+	}
+	errors = append(errors, t.commitBatch()...)
+	return t.results, errors
+}
+
+// writes alias to k2v and marks it as an alias of v in t. Aliases
+// expire with the entry they resolve to at expiresAt.
+func (s *BadgerStore) setAliasInDB(t *txnPair, alias string, v int64, expiresAt uint64) error {
+	if err := setWithExpiry(t.v2k, s.aliasKey(v, alias), []byte{}, expiresAt); err != nil {
+		return err
+	}
+	return s.setKeyInDB(t, alias, v, expiresAt)
+}
+
+// removes alias of v from both k2v and the alias list of v in t
+func (s *BadgerStore) deleteAliasFromDB(t *txnPair, alias string, v int64) error {
+	if err := t.v2k.Delete(s.aliasKey(v, alias)); err != nil {
+		return err
+	}
+	return s.deleteKeyFromDB(t, alias)
+}
+
+// writes key k resolving to v to k2v and its indexes in t
+func (s *BadgerStore) setKeyInDB(t *txnPair, k string, v int64, expiresAt uint64) error {
+	for _, indexKey := range s.indexKeys(k) {
+		if err := setWithExpiry(t.v2k, indexKey, append(encodeValue(v), k...), expiresAt); err != nil {
+			return err
+		}
+	}
+	return setWithExpiry(t.k2v, s.kKey(k), encodeValue(v), expiresAt)
+}
+
+// removes key k from k2v and its indexes in t
+func (s *BadgerStore) deleteKeyFromDB(t *txnPair, k string) error {
+	for _, indexKey := range s.indexKeys(k) {
+		if err := t.v2k.Delete(indexKey); err != nil {
+			return err
+		}
+	}
+	return t.k2v.Delete(s.kKey(k))
+}
+
+// keys under which key k is kept in the folded index and, if enabled,
+// the trigram index. Each holds the value of k followed by k.
+func (s *BadgerStore) indexKeys(k string) (indexKeys [][]byte) {
+	indexKeys = append(indexKeys, s.foldedKey(k))
+	if s.trigrams {
+		for _, gram := range trigramsOf(k) {
+			indexKeys = append(indexKeys, s.trigramKey(gram, k))
+		}
+	}
+	return indexKeys
+}
+
+// decodes an entry of the folded or trigram index
+func decodeIndexEntry(b []byte) (Entry, error) {
+	if len(b) < 8 {
+		return Entry{}, fmt.Errorf("Invalid index entry %q", b)
+	}
+	v, err := decodeValue(b[:8])
+	return Entry{Key: string(b[8:]), Value: v}, err
+}
+
+// is k an alias of the entry with value v
+func (s *BadgerStore) isAlias(txn *badger.Txn, k string, v int64) (bool, error) {
+	_, err := txn.Get(s.aliasKey(v, k))
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// sorted aliases of the entry with value v
+func (s *BadgerStore) aliasesOf(txn *badger.Txn, v int64) (aliases []string) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	prefix := s.aliasKey(v, "")
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		aliases = append(aliases, string(it.Item().Key()[len(prefix):]))
+	}
+	return aliases
+}
+
+// reads up to n distinct random entries matching filter from DB, fewer
+// if fewer match. Every matching entry is equally likely to be picked:
+// without a filter, distinct random slots of the ordinal index are read
+// with a point lookup each until n entries were found. Slots of expired
+// entries are skipped, and dropped from the index afterwards.
+func (s *BadgerStore) ReadRandomEntries(
+	n int,
+	filter RandomFilter,
+) (
+	entries []Entry,
+	err error,
+) {
+	if !filter.matchesAll() {
+		return s.readFilteredRandomEntries(n, filter)
+	}
+	entries = []Entry{}
+	expired := []int64{}
+	err = s.V2k.View(func(txn *badger.Txn) error {
+		count, _, err := readEncoded(txn, s.metaKey(ORDINAL_COUNT_KEY))
+		if err != nil {
+			return err
+		}
+		// partial Fisher-Yates shuffle of the slots, swapped holds the
+		// slots moved so far
+		swapped := make(map[int64]int64)
+		slotAt := func(i int64) int64 {
+			if slot, ok := swapped[i]; ok {
+				return slot
+			}
+			return i
+		}
+		for i := int64(0); i < count && len(entries) < n; i++ {
+			j := i + rand.Int63n(count-i)
+			slot := slotAt(j)
+			swapped[j] = slotAt(i)
+			v, found, err := readEncoded(txn, s.ordinalKey(slot))
+			if err != nil {
+				return err
+			}
+			if !found || !valueInRange(v) {
+				continue
+			}
+			item, err := txn.Get(s.vKey(v))
+			if err == badger.ErrKeyNotFound {
+				expired = append(expired, v)
+				continue
+			} else if err != nil {
+				return err
+			}
+			key, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, Entry{Key: string(key), Value: v, Display: s.displayOf(txn, string(key), v)})
+		}
+		return nil
+	})
+	if err == nil && len(expired) > 0 {
+		if err := s.dropExpiredOrdinals(expired); err != nil {
+			logErr("Could not drop expired entries from the ordinal index: %v", err)
+		}
+	}
+	return entries, err
+}
+
+// removes the values of expired entries from the ordinal index, up to
+// TXN_BATCH_SIZE at a time. Skips values written again since.
+func (s *BadgerStore) dropExpiredOrdinals(values []int64) error {
+	if len(values) > TXN_BATCH_SIZE {
+		values = values[:TXN_BATCH_SIZE]
+	}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	return s.V2k.Update(func(txn *badger.Txn) error {
+		for _, v := range values {
+			if _, err := txn.Get(s.vKey(v)); err == nil {
+				continue
+			} else if err != badger.ErrKeyNotFound {
+				return err
+			}
+			if err := s.deleteOrdinalFromDB(txn, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// reads up to n distinct random entries matching filter by reservoir
+// sampling over all keys starting with its prefix. Aliases are skipped
+// so that each entry is counted once. Reads every key under the prefix,
+// all keys of the store without one, and with metadata in the filter
+// the metadata of each of them, since there is no index to sample from.
+func (s *BadgerStore) readFilteredRandomEntries(n int, filter RandomFilter) (entries []Entry, err error) {
+	entries = []Entry{}
+	prefix := s.kKey(s.normalizer.NormalizePrefix(filter.Prefix))
+	err = s.view(func(t *txnPair) error {
+		it := t.k2v.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		seen := 0
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			if s.isNamespaceKey(item.Key()) {
+				break
+			}
+			key := string(item.Key()[len(s.kPrefix):])
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			val, err := decodeValue(v)
+			if err != nil {
+				return err
+			}
+			if !valueInRange(val) {
+				continue
+			}
+			if isAlias, err := s.isAlias(t.v2k, key, val); err != nil {
+				return err
+			} else if isAlias {
+				continue
+			}
+			if len(filter.Metadata) > 0 {
+				var m []byte
+				if item, err := t.v2k.Get(s.metadataKey(val)); err == nil {
+					m, _ = item.ValueCopy(nil)
+				} else if err != badger.ErrKeyNotFound {
+					return err
+				}
+				if !metadataMatches(m, filter.Metadata) {
+					continue
+				}
+			}
+			seen++
+			e := Entry{Key: key, Value: val}
+			if len(entries) < n {
+				entries = append(entries, e)
+			} else if i := rand.Intn(seen); i < n {
+				// replace with probability n / seen
+				entries[i] = e
+			}
+		}
+		for i, e := range entries {
+			entries[i].Display = s.displayOf(t.v2k, e.Key, e.Value)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// warns if the store holds values outside of the configured bounds,
+// e.g. after narrowing them. Their entries can't be looked up by value
+// and aren't sampled by /random until the bounds include them again.
+func (s *BadgerStore) checkValueBounds() {
+	s.V2k.View(func(txn *badger.Txn) error {
+		below := MIN_VALUE > 0 && s.hasValueBetween(txn, 0, MIN_VALUE-1)
+		above := MAX_VALUE < math.MaxInt64 && s.hasValueBetween(txn, MAX_VALUE+1, math.MaxInt64)
+		if below || above {
+			logWarn("Store holds values outside of [%d, %d], their entries are hidden from /entriesFromValues and /random until GRAPH_DB_MIN_VALUE and GRAPH_DB_MAX_VALUE include them", MIN_VALUE, MAX_VALUE)
+		}
+		return nil
+	})
+}
+
+// is any value in [lo, hi] stored in v2k
+func (s *BadgerStore) hasValueBetween(txn *badger.Txn, lo int64, hi int64) bool {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	it.Seek(s.vKey(lo))
+	if !it.ValidForPrefix(s.vPrefix) {
+		return false
+	}
+	k := it.Item().Key()
+	return !isMetaKey(k[len(s.vPrefix):]) && bytes.Compare(k, s.vKey(hi)) <= 0
+}
+
+// retrieves entries from k2v DB
+func (s *BadgerStore) GetEntriesFromKeys(keys []string) (entries []Entry, errors []string) {
+	s.view(func(t *txnPair) error {
+		for _, k := range s.normalizer.NormalizeAll(keys) {
+			if err := checkKeys(k); err != nil {
+				errors = append(errors, err.Error())
+				continue
+			}
+			item, err := t.k2v.Get(s.kKey(k))
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("Could not retrieve entry from key %s: %s", k, err.Error()))
+			} else {
+				// add to response
+				v, _ := item.ValueCopy(nil)
+				val, _ := decodeValue(v)
+				entries = append(entries, Entry{Key: k, Value: val, Display: s.displayOf(t.v2k, k, val)})
+			}
+		}
+		return nil
+	})
+	return entries, errors
+}
+
+// retrieves entries from v2k DB
+func (s *BadgerStore) GetEntriesFromValues(values []int64) (entries []Entry, errors []string) {
+	s.V2k.View(func(txn *badger.Txn) error {
+		for _, v := range values {
+			if !valueInRange(v) {
+				errors = append(errors, fmt.Sprintf("Could not retrieve entry from value %d: %s", v, valueOutOfRangeError(v).Error()))
+				continue
+			}
+			item, err := txn.Get(s.vKey(v))
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("Could not retrieve entry from value %d: %s", v, err.Error()))
+			} else {
+				// add to response
+				key, _ := item.ValueCopy(nil)
+				entries = append(entries, Entry{Key: string(key), Value: v, Aliases: s.aliasesOf(txn, v), Display: s.displayOf(txn, string(key), v)})
+			}
+		}
+		return nil
+	})
+	return entries, errors
+}
+
+// lists up to limit entries created after position after, ordered by
+// creation time and value
+func (s *BadgerStore) ListCreated(after CreatedCursor, limit int) (entries []Entry, errors []string) {
+	entries = []Entry{}
+	errors = []string{}
+	s.V2k.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := s.metaKey(CREATED_KEY)
+		start := s.createdKey(after)
+		for it.Seek(start); it.ValidForPrefix(prefix) && len(entries) < limit; it.Next() {
+			if bytes.Equal(it.Item().Key(), start) {
+				continue
+			}
+			c, err := createdCursorFromBytes(it.Item().Key()[len(prefix):])
+			if err != nil {
+				errors = append(errors, err.Error())
+				continue
+			}
+			item, err := txn.Get(s.vKey(c.Value))
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("Could not retrieve entry from value %d: %s", c.Value, err.Error()))
+				continue
+			}
+			key, _ := item.ValueCopy(nil)
+			entries = append(entries, Entry{Key: string(key), Value: c.Value, Created: &c.Created, Display: s.displayOf(txn, string(key), c.Value)})
+		}
+		return nil
 	})
 	return entries, errors
 }
+
+// retrieves up to limit entries with keys starting with q, after the
+// key of the cursor
+func (s *BadgerStore) SeekWithPrefix(q string, after SearchCursor, limit int) (entries []Entry, errors []string) {
+	s.view(func(t *txnPair) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := t.k2v.NewIterator(opts)
+		defer it.Close()
+		prefix := s.kKey(s.normalizer.NormalizePrefix(q))
+		var start []byte
+		if after.Key != "" {
+			start = s.kKey(after.Key)
+		}
+		nFound := 0
+		for it.Seek(seekStart(prefix, start)); it.ValidForPrefix(prefix) && nFound < limit; it.Next() {
+			item := it.Item()
+			if s.isNamespaceKey(item.Key()) {
+				break
+			}
+			if bytes.Equal(item.Key(), start) {
+				continue
+			}
+			// add to response
+			key := string(item.Key()[len(s.kPrefix):])
+			v, _ := item.ValueCopy(nil)
+			val, _ := decodeValue(v)
+			entries = append(entries, Entry{Key: key, Value: val, Display: s.displayOf(t.v2k, key, val)})
+			nFound++
+		}
+		return nil
+	})
+	return entries, errors
+}
+
+// retrieves up to limit entries with keys starting with q ignoring
+// case, ordered by their case folded keys, after the key of the cursor
+func (s *BadgerStore) SeekWithFoldedPrefix(q string, after SearchCursor, limit int) (entries []Entry, errors []string) {
+	s.V2k.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := s.metaKey(FOLDED_KEY + foldKey(s.normalizer.NormalizePrefix(q)))
+		var start []byte
+		if after.Key != "" {
+			start = s.foldedKey(after.Key)
+		}
+		for it.Seek(seekStart(prefix, start)); it.ValidForPrefix(prefix) && len(entries) < limit; it.Next() {
+			if bytes.Equal(it.Item().Key(), start) {
+				continue
+			}
+			b, _ := it.Item().ValueCopy(nil)
+			e, err := decodeIndexEntry(b)
+			if err != nil {
+				errors = append(errors, err.Error())
+				continue
+			}
+			e.Display = s.displayOf(txn, e.Key, e.Value)
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return entries, errors
+}
+
+// calls f with up to limit entries in r after the key of the cursor,
+// in key order. A limit of 0 scans all of r. Entries are read in a
+// single transaction and handed to f as they are read.
+func (s *BadgerStore) ScanKeys(r KeyRange, after SearchCursor, limit int, f func(Entry) error) error {
+	return s.view(func(t *txnPair) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := t.k2v.NewIterator(opts)
+		defer it.Close()
+		start := s.kKey(r.Start)
+		if after.Key != "" {
+			start = seekStart(start, s.kKey(after.Key))
+		}
+		n := 0
+		for it.Seek(start); it.ValidForPrefix(s.kPrefix) && (limit == 0 || n < limit); it.Next() {
+			item := it.Item()
+			if s.isNamespaceKey(item.Key()) {
+				break
+			}
+			key := string(item.Key()[len(s.kPrefix):])
+			if !r.contains(key) {
+				break
+			}
+			if after.Key != "" && key == after.Key {
+				continue
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			val, err := decodeValue(v)
+			if err != nil {
+				return err
+			}
+			if err := f(Entry{Key: key, Value: val, Display: s.displayOf(t.v2k, key, val)}); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+}
+
+// calls f with up to limit entries with values in r after the value of
+// the cursor, in numeric order. A limit of 0 scans all of r. Values are
+// stored big endian, so v2k is ordered numerically.
+func (s *BadgerStore) ScanValues(r ValueRange, after SearchCursor, limit int, f func(Entry) error) error {
+	r = r.after(after)
+	if r.Min < 0 {
+		r.Min = 0
+	}
+	if r.Max < r.Min {
+		return nil
+	}
+	return s.view(func(t *txnPair) error {
+		it := t.v2k.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		end := s.vKey(r.Max)
+		n := 0
+		for it.Seek(s.vKey(r.Min)); it.ValidForPrefix(s.vPrefix) && (limit == 0 || n < limit); it.Next() {
+			item := it.Item()
+			if isMetaKey(item.Key()[len(s.vPrefix):]) || bytes.Compare(item.Key(), end) > 0 {
+				break
+			}
+			val, err := decodeValue(item.Key()[len(s.vPrefix):])
+			if err != nil {
+				return err
+			}
+			key, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := f(Entry{Key: string(key), Value: val, Display: s.displayOf(t.v2k, string(key), val)}); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+}
+
+// where a prefix scan continuing after start begins
+func seekStart(prefix []byte, start []byte) []byte {
+	if bytes.Compare(start, prefix) > 0 {
+		return start
+	}
+	return prefix
+}
+
+// retrieves up to limit entries with keys containing q ignoring case,
+// ranked by containsRank, after the key of the cursor. Needs the
+// trigram index.
+func (s *BadgerStore) SearchContains(q string, after SearchCursor, limit int) (entries []Entry, errors []string) {
+	entries = []Entry{}
+	if !s.trigrams {
+		return entries, []string{ErrNoTrigramIndex.Error()}
+	}
+	q = foldKey(s.normalizer.Normalize(q))
+	if q == "" {
+		return entries, errors
+	}
+	err := s.view(func(t *txnPair) error {
+		seen := make(map[string]bool)
+		// adds e if it contains q, false once enough keys were found
+		add := func(e Entry) bool {
+			if seen[e.Key] || !strings.Contains(foldKey(e.Key), q) {
+				return true
+			}
+			if len(entries) == MAX_SEARCH_CANDIDATES {
+				errors = append(errors, tooManyCandidatesError(q).Error())
+				return false
+			}
+			seen[e.Key] = true
+			entries = append(entries, e)
+			return true
+		}
+		err := s.scanContaining(t.v2k, q, add)
+		for i, e := range entries {
+			entries[i].Display = s.displayOf(t.v2k, e.Key, e.Value)
+		}
+		return err
+	})
+	if err != nil {
+		errors = append(errors, err.Error())
+	}
+	rank := func(e Entry) rankedEntry { return containsRank(e, q) }
+	return rankedPage(rankContains(entries, q), after, rank, limit), errors
+}
+
+// calls f with the entries of the index under prefix until f returns
+// false
+func (s *BadgerStore) scanIndex(txn *badger.Txn, prefix []byte, f func(Entry) bool) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		b, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		e, err := decodeIndexEntry(b)
+		if err != nil {
+			return err
+		}
+		if !f(e) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// calls f with the entries of keys indexed under at least need of
+// grams, in key order, until f returns false. No key before the need-th
+// smallest key the posting lists are at can be in need of them, so the
+// lists behind it are seeked straight to it. With need of all grams this
+// leapfrogs between the lists, so that the shortest bounds the seeks.
+func (s *BadgerStore) mergeTrigrams(txn *badger.Txn, grams []string, need int, f func(Entry) bool) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	its := make([]*badger.Iterator, len(grams))
+	prefixes := make([][]byte, len(grams))
+	for i, gram := range grams {
+		its[i] = txn.NewIterator(opts)
+		defer its[i].Close()
+		prefixes[i] = s.trigramKey(gram, "")
+		its[i].Seek(prefixes[i])
+	}
+	at := make([]string, len(its))
+	for {
+		// keys the lists are at, "" for lists which ended
+		sorted := []string{}
+		for i, it := range its {
+			at[i] = ""
+			if it.ValidForPrefix(prefixes[i]) {
+				at[i] = string(it.Item().Key()[len(prefixes[i]):])
+				sorted = append(sorted, at[i])
+			}
+		}
+		if len(sorted) < need {
+			return nil
+		}
+		sort.Strings(sorted)
+		k := sorted[need-1]
+		seek := k
+		if sorted[0] == k {
+			// k is in need lists
+			for i, it := range its {
+				if at[i] != k || !it.ValidForPrefix(prefixes[i]) {
+					continue
+				}
+				b, err := it.Item().ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				e, err := decodeIndexEntry(b)
+				if err != nil {
+					return err
+				}
+				if !f(e) {
+					return nil
+				}
+				break
+			}
+			// smallest key after k
+			seek = k + "\x00"
+		}
+		for i, it := range its {
+			if it.ValidForPrefix(prefixes[i]) && at[i] < seek {
+				it.Seek(append(append([]byte{}, prefixes[i]...), seek...))
+			}
+		}
+	}
+}
+
+// calls f with the entries of keys containing the folded substring q
+// until f returns false, and possibly of other keys starting grams with
+// q if q is shorter than three runes
+func (s *BadgerStore) scanContaining(txn *badger.Txn, q string, f func(Entry) bool) error {
+	if grams := queryTrigrams(q); len(grams) > 0 {
+		return s.mergeTrigrams(txn, grams, len(grams), f)
+	}
+	// shorter substrings start a gram of every key containing them
+	return s.scanIndex(txn, s.metaKey(TRIGRAM_KEY+q), f)
+}
+
+// store of the namespace name. Namespaces keep their entries and
+// bookkeeping under their own prefix of the bookkeeping keys of s, so that
+// they never collide with s or other namespaces.
+func (s *BadgerStore) Namespace(name string) (Store, error) {
+	if s.namespace != "" {
+		return nil, ErrNestedNamespace
+	}
+	s.nsLock.Lock()
+	defer s.nsLock.Unlock()
+	if ns, ok := s.namespaces[name]; ok {
+		return ns, nil
+	}
+	err := s.V2k.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(s.metaKey(NAMESPACE_KEY + name))
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, namespaceNotFoundError(name)
+	} else if err != nil {
+		return nil, err
+	}
+	prefix := s.metaKey(NAMESPACE_PREFIX + name + "/")
+	ns := &BadgerStore{
+		K2v:        s.K2v,
+		V2k:        s.V2k,
+		kPrefix:    append(append([]byte{}, prefix...), K2V_PREFIX...),
+		vPrefix:    append(append([]byte{}, prefix...), V2K_PREFIX...),
+		mPrefix:    append(append([]byte{}, prefix...), META_PREFIX...),
+		namespace:  name,
+		normalizer: s.normalizer,
+		trigrams:   s.trigrams,
+	}
+	if err := ns.SetAllocation(s.allocation); err != nil {
+		return nil, err
+	}
+	if err := ns.BuildIndexes(); err != nil {
+		return nil, err
+	}
+	if err := ns.NormalizeKeys(); err != nil {
+		return nil, err
+	}
+	if s.namespaces == nil {
+		s.namespaces = make(map[string]*BadgerStore)
+	}
+	s.namespaces[name] = ns
+	return ns, nil
+}
+
+// adds a new namespace
+func (s *BadgerStore) CreateNamespace(name string) error {
+	if s.namespace != "" {
+		return ErrNestedNamespace
+	}
+	if err := checkNamespaceName(name); err != nil {
+		return err
+	}
+	s.nsLock.Lock()
+	defer s.nsLock.Unlock()
+	return s.V2k.Update(func(txn *badger.Txn) error {
+		key := s.metaKey(NAMESPACE_KEY + name)
+		if _, err := txn.Get(key); err == nil {
+			return namespaceExistsError(name)
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		return txn.Set(key, []byte{})
+	})
+}
+
+// sorted names of all namespaces
+func (s *BadgerStore) ListNamespaces() (names []string, err error) {
+	names = []string{}
+	if s.namespace != "" {
+		return names, ErrNestedNamespace
+	}
+	err = s.V2k.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := s.metaKey(NAMESPACE_KEY)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			names = append(names, string(it.Item().Key()[len(prefix):]))
+		}
+		return nil
+	})
+	return names, err
+}
+
+// copies a split layout store under GRAPH_DB_STORE_DIR into the single
+// layout. The old k2v and v2k directories are left untouched. Refuses to
+// copy into a single layout store which already holds data, since that
+// may be newer than the split layout.
+func MigrateToSingleLayout() error {
+	k2v, v2k, err := ConnectToDb()
+	if err != nil {
+		return err
+	}
+	defer k2v.Close()
+	defer v2k.Close()
+	db, err := ConnectToSingleDb()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if empty, err := isEmpty(db); err != nil {
+		return err
+	} else if !empty {
+		return fmt.Errorf("%s already holds data, remove it before migrating again", os.Getenv("GRAPH_DB_STORE_DIR")+DB_PATH)
+	}
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, src := range []struct {
+		db     *badger.DB
+		prefix []byte
+	}{{k2v, K2V_PREFIX}, {v2k, V2K_PREFIX}} {
+		n, err := copyWithPrefix(src.db, wb, src.prefix)
+		if err != nil {
+			return err
+		}
+		logMsg("Copied %d entries under prefix '%s'", n, src.prefix)
+	}
+	return wb.Flush()
+}
+
+// does db hold no keys
+func isEmpty(db *badger.DB) (empty bool, err error) {
+	err = db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		it.Rewind()
+		empty = !it.Valid()
+		return nil
+	})
+	return empty, err
+}
+
+// writes every key of db to wb under prefix, bookkeeping keys are
+// written unprefixed
+func copyWithPrefix(db *badger.DB, wb *badger.WriteBatch, prefix []byte) (n int, err error) {
+	err = db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			k := append(append([]byte{}, prefix...), item.Key()...)
+			if isMetaKey(item.Key()) {
+				k = item.KeyCopy(nil)
+			}
+			// keep the expiry of entries created with a ttl
+			if err := wb.SetEntry(&badger.Entry{Key: k, Value: v, ExpiresAt: item.ExpiresAt()}); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// retrieves up to limit entries with keys within the edit distance of
+// q ignoring case, closest first, after the key of the cursor. Needs
+// the trigram index: candidates are the keys sharing enough trigrams
+// with q, or for queries with too few trigrams the keys containing one
+// of the pigeonholePieces of q. At most MAX_SEARCH_CANDIDATES of them
+// are compared with q.
+func (s *BadgerStore) SearchFuzzy(q string, distance int, after SearchCursor, limit int) (entries []Entry, errors []string) {
+	entries = []Entry{}
+	if !s.trigrams {
+		return entries, []string{ErrNoTrigramIndex.Error()}
+	}
+	q = foldKey(s.normalizer.Normalize(q))
+	length := utf8.RuneCountInString(q)
+	candidates := []Entry{}
+	seen := make(map[string]bool)
+	// adds e if its length is close enough to q, false once enough
+	// candidates were found
+	add := func(e Entry) bool {
+		n := utf8.RuneCountInString(foldKey(e.Key))
+		if seen[e.Key] || n > length+distance || n < length-distance {
+			return true
+		}
+		if len(candidates) == MAX_SEARCH_CANDIDATES {
+			errors = append(errors, tooManyFuzzyCandidatesError(q).Error())
+			return false
+		}
+		seen[e.Key] = true
+		candidates = append(candidates, e)
+		return true
+	}
+	err := s.view(func(t *txnPair) error {
+		err := s.fuzzyCandidates(t.v2k, q, distance, add)
+		for i, e := range candidates {
+			candidates[i].Display = s.displayOf(t.v2k, e.Key, e.Value)
+		}
+		return err
+	})
+	if err != nil {
+		errors = append(errors, err.Error())
+	}
+	rank := func(e Entry) rankedEntry { return fuzzyRank(e, []rune(q)) }
+	return rankedPage(fuzzyMatches(candidates, q, distance), after, rank, limit), errors
+}
+
+// calls f with the entries of every key within the edit distance of the
+// folded query q, and others, until f returns false
+func (s *BadgerStore) fuzzyCandidates(txn *badger.Txn, q string, distance int, f func(Entry) bool) error {
+	grams := queryTrigrams(q)
+	if need := minSharedTrigrams(grams, distance); need >= 1 {
+		return s.mergeTrigrams(txn, grams, need, f)
+	}
+	pieces := pigeonholePieces(q, distance)
+	if pieces == nil {
+		// every short key is within the distance
+		return s.scanIndex(txn, s.metaKey(FOLDED_KEY), f)
+	}
+	for _, piece := range pieces {
+		done := false
+		err := s.scanContaining(txn, piece, func(e Entry) bool {
+			if !strings.Contains(foldKey(e.Key), piece) {
+				return true
+			}
+			done = !f(e)
+			return !done
+		})
+		if err != nil || done {
+			return err
+		}
+	}
+	return nil
+}
+
+// adds every key to the folded index and every value to the ordinal
+// index, for stores written before they existed, and every key to the
+// trigram index when it was turned on. Drops the
+// trigram index when it was turned off, so that it is rebuilt rather
+// than left stale when turned on again. Returns immediately if the
+// indexes match the configuration.
+func (s *BadgerStore) BuildIndexes() error {
+	folded, ordinal, trigrams := false, false, false
+	err := s.V2k.View(func(txn *badger.Txn) (err error) {
+		if folded, err = hasKey(txn, s.metaKey(FOLDED_INDEX_KEY)); err != nil {
+			return err
+		}
+		if ordinal, err = hasKey(txn, s.metaKey(ORDINAL_INDEX_KEY)); err != nil {
+			return err
+		}
+		trigrams, err = hasKey(txn, s.metaKey(TRIGRAM_INDEX_KEY))
+		return err
+	})
+	if err != nil || (folded && ordinal && trigrams == s.trigrams) {
+		return err
+	}
+	return s.RebuildIndexes()
+}
+
+// is k set in txn
+func hasKey(txn *badger.Txn, k []byte) (bool, error) {
+	_, err := txn.Get(k)
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// renames keys stored before GRAPH_DB_KEY_NORMALIZATION was changed to
+// their normalized form, keeping their original form as display form if
+// enabled. Fails before renaming anything if two keys normalize to the
+// same key.
+func (s *BadgerStore) NormalizeKeys() error {
+	steps := s.normalizer.String()
+	normalized := ""
+	err := s.V2k.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(s.metaKey(NORMALIZATION_KEY))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		b, err := item.ValueCopy(nil)
+		normalized = string(b)
+		return err
+	})
+	if err != nil || normalized == steps {
+		return err
+	}
+	renames, err := s.normalizingRenames()
+	if err != nil {
+		return err
+	}
+	logMsg("Normalizing %d keys with '%s'", len(renames), steps)
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	t := s.newTxnPair(true)
+	defer t.Discard()
+	for _, r := range renames {
+		e, err := s.renameKeyInDB(t, r, s.normalizer.Display(r.From, r.To))
+		if err != nil {
+			return err
+		}
+		if failed := t.checkpoint(e, true); len(failed) > 0 {
+			return fmt.Errorf("%s", failed[0])
+		}
+	}
+	if err := t.v2k.Set(s.metaKey(NORMALIZATION_KEY), []byte(steps)); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// renames of all keys which aren't in normalized form. Fails if two keys
+// normalize to the same key.
+func (s *BadgerStore) normalizingRenames() (renames []Rename, err error) {
+	// key renamed to each normalized key
+	from := map[string]string{}
+	err = s.ScanKeys(KeyRange{}, SearchCursor{}, 0, func(e Entry) error {
+		k := s.normalizer.Normalize(e.Key)
+		if k == e.Key {
+			return nil
+		}
+		if other, ok := from[k]; ok {
+			return normalizationConflictError(other, e.Key, k)
+		}
+		from[k] = e.Key
+		renames = append(renames, Rename{e.Key, k})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// keys which already are in normalized form
+	err = s.view(func(t *txnPair) error {
+		for _, r := range renames {
+			if _, err := t.k2v.Get(s.kKey(r.To)); err == nil {
+				return normalizationConflictError(r.To, r.From, r.To)
+			} else if err != badger.ErrKeyNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	return renames, err
+}
+
+// rebuilds the folded index and, if enabled, the trigram index from
+// k2v, and the ordinal index from v2k. The trigram index is dropped if
+// it isn't enabled.
+func (s *BadgerStore) RebuildIndexes() error {
+	indexes := []string{FOLDED_KEY, TRIGRAM_KEY, TRIGRAM_INDEX_KEY, ORDINAL_KEY, SLOT_KEY, ORDINAL_COUNT_KEY}
+	for _, index := range indexes {
+		n, err := deleteWithPrefix(s.V2k, s.metaKey(index))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			logMsg("Dropped %d entries of index '%s'", n, index)
+		}
+	}
+	wb := s.V2k.NewWriteBatch()
+	defer wb.Cancel()
+	n := 0
+	err := s.K2v.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(s.kPrefix); it.ValidForPrefix(s.kPrefix); it.Next() {
+			item := it.Item()
+			if s.isNamespaceKey(item.Key()) {
+				break
+			}
+			k := string(item.Key()[len(s.kPrefix):])
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			for _, indexKey := range s.indexKeys(k) {
+				err = wb.SetEntry(&badger.Entry{
+					Key:       indexKey,
+					Value:     append(append([]byte{}, v...), k...),
+					ExpiresAt: item.ExpiresAt(),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			n++
+		}
+		return nil
+	})
+	slots := int64(0)
+	if err == nil {
+		slots, err = s.buildOrdinalIndex(wb)
+	}
+	if err == nil {
+		err = wb.Set(s.metaKey(FOLDED_INDEX_KEY), []byte{})
+	}
+	if err == nil {
+		err = wb.Set(s.metaKey(ORDINAL_INDEX_KEY), []byte{})
+	}
+	if err == nil && s.trigrams {
+		err = wb.Set(s.metaKey(TRIGRAM_INDEX_KEY), []byte{})
+	}
+	if err != nil {
+		return err
+	}
+	if err := wb.Flush(); err != nil {
+		return err
+	}
+	if n > 0 {
+		logMsg("Indexed %d keys and %d values", n, slots)
+	}
+	return nil
+}
+
+// writes a slot for each value stored in v2k and the number of slots to
+// wb, returns that number
+func (s *BadgerStore) buildOrdinalIndex(wb *badger.WriteBatch) (n int64, err error) {
+	err = s.V2k.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(s.vPrefix); it.ValidForPrefix(s.vPrefix); it.Next() {
+			k := it.Item().Key()
+			if isMetaKey(k[len(s.vPrefix):]) {
+				break
+			}
+			v, err := s.parseVKey(k)
+			if err != nil {
+				continue
+			}
+			if err := wb.Set(s.ordinalKey(n), encodeValue(v)); err != nil {
+				return err
+			}
+			if err := wb.Set(s.slotKey(v), encodeValue(n)); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, wb.Set(s.metaKey(ORDINAL_COUNT_KEY), encodeValue(n))
+}
+
+// deletes every key of db under prefix
+func deleteWithPrefix(db *badger.DB, prefix []byte) (n int, err error) {
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+	err = db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := wb.Delete(it.Item().KeyCopy(nil)); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, wb.Flush()
+}
+
+// rebuilds the indexes of the store under GRAPH_DB_STORE_DIR and of
+// all of its namespaces
+func RebuildAllIndexes() error {
+	s, err := NewBadgerStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	names, err := s.ListNamespaces()
+	if err != nil {
+		return err
+	}
+	stores := []*BadgerStore{s}
+	for _, name := range names {
+		ns, err := s.Namespace(name)
+		if err != nil {
+			return err
+		}
+		stores = append(stores, ns.(*BadgerStore))
+	}
+	for _, store := range stores {
+		if store.namespace != "" {
+			logMsg("Rebuilding indexes of namespace %s", store.namespace)
+		}
+		if err := store.RebuildIndexes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewrites values stored as decimal strings by older versions with
+// encodeValue. Runs once per store, later calls return immediately.
+func (s *BadgerStore) MigrateValueEncoding() error {
+	formatKey := s.metaKey(FORMAT_KEY)
+	err := s.V2k.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(formatKey)
+		return err
+	})
+	if err == nil {
+		// already migrated
+		return nil
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
+	logMsg("Migrating values to binary encoding")
+	nV2k, err := migrateDecimalStrings(s.V2k, s.vPrefix, true)
+	if err != nil {
+		return err
+	}
+	nK2v, err := migrateDecimalStrings(s.K2v, s.kPrefix, false)
+	if err != nil {
+		return err
+	}
+	logMsg("Migrated %d v2k and %d k2v entries", nV2k, nK2v)
+	return s.V2k.Update(func(txn *badger.Txn) error {
+		return txn.Set(formatKey, []byte(VALUE_FORMAT_BINARY))
+	})
+}
+
+// rewrites every entry under prefix whose value is a decimal string.
+// The value is the suffix of the key after prefix in v2k (keyHoldsValue)
+// and the stored value in k2v.
+func migrateDecimalStrings(db *badger.DB, prefix []byte, keyHoldsValue bool) (n int, err error) {
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+	err = db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			if isMetaKey(item.Key()) {
+				continue
+			}
+			k := item.KeyCopy(nil)
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			decimal := v
+			if keyHoldsValue {
+				decimal = k[len(prefix):]
+			}
+			val, err := strconv.ParseInt(string(decimal), 10, 64)
+			if err != nil {
+				logWarn("Skipping entry %q with invalid value %q", k, decimal)
+				continue
+			}
+			if keyHoldsValue {
+				// move entry to its binary key
+				newK := append(append([]byte{}, prefix...), encodeValue(val)...)
+				if err = wb.Delete(k); err == nil {
+					err = wb.Set(newK, v)
+				}
+			} else {
+				err = wb.Set(k, encodeValue(val))
+			}
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, wb.Flush()
+}
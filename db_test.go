@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	badger "github.com/dgraph-io/badger"
 	"github.com/stretchr/testify/assert"
@@ -8,22 +9,80 @@ import (
 	"math/rand"
 	"os"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 func _WriteEntryHelper(k2v *badger.DB, v2k *badger.DB, s string) error {
-	v := rand.Intn(INT_MAX)
+	v := randomValue()
 	k2v.Update(func(txn *badger.Txn) error {
-		err := txn.Set([]byte(s), []byte(strconv.Itoa(v)))
+		err := txn.Set([]byte(s), encodeValue(v))
 		return err
 	})
 	v2k.Update(func(txn *badger.Txn) error {
-		err := txn.Set([]byte(strconv.Itoa(v)), []byte(s))
+		err := txn.Set(encodeValue(v), []byte(s))
 		return err
 	})
 	return nil
 }
 
+// creates overlapping keys from many goroutines and asserts that every key
+// resolved to exactly one value and that no value was handed out twice
+func _AssertConcurrentCreatesAreUnique(t *testing.T, s Store) {
+	// small value space to force collisions between new keys
+	MAX_VALUE = 20000
+	defer func() { MAX_VALUE = 999999999 }()
+	nWorkers := 20
+	nKeys := 2000
+	results := make([][]Entry, nWorkers)
+	wg := sync.WaitGroup{}
+	for w := 0; w < nWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			keys := []string{}
+			for i := 0; i < nKeys; i++ {
+				// every worker races on the same keys in a different order
+				keys = append(keys, "concurrent"+strconv.Itoa((i*7+w)%nKeys))
+			}
+			entries, errors := s.CreateIfDoesntExist(keys, true)
+			assert.Equal(t, []string{}, errors)
+			results[w] = entries
+		}(w)
+	}
+	wg.Wait()
+
+	k2v := map[string]int64{}
+	for _, entries := range results {
+		require.Equal(t, nKeys, len(entries))
+		for _, e := range entries {
+			if v, ok := k2v[e.Key]; ok {
+				assert.Equal(t, v, e.Value, "key %s was assigned two values", e.Key)
+			}
+			k2v[e.Key] = e.Value
+		}
+	}
+	require.Equal(t, nKeys, len(k2v))
+	v2k := map[int64]string{}
+	for k, v := range k2v {
+		if other, ok := v2k[v]; ok {
+			t.Errorf("value %d was assigned to both %s and %s", v, k, other)
+		}
+		v2k[v] = k
+	}
+	// make sure what was returned is what was stored
+	values := []int64{}
+	for v := range v2k {
+		values = append(values, v)
+	}
+	entries, errors := s.GetEntriesFromValues(values)
+	assert.Equal(t, 0, len(errors))
+	for _, e := range entries {
+		assert.Equal(t, k2v[e.Key], e.Value)
+	}
+}
+
 func TestConnectToDb(t *testing.T) {
 	// setup
 	os.MkdirAll(testingDir, os.ModePerm)
@@ -50,7 +109,7 @@ func TestConnectToDb(t *testing.T) {
 	})
 
 	t.Run("loads db if already exists", func(t *testing.T) {
-		loadPath := "/tmp/twowaykv/iotest/" + strconv.Itoa(rand.Intn(INT_MAX))
+		loadPath := "/tmp/twowaykv/iotest/" + strconv.Itoa(rand.Int())
 		err := os.MkdirAll(loadPath, os.ModePerm)
 		require.NoError(t, err)
 		defer os.RemoveAll(loadPath)
@@ -115,6 +174,7 @@ func TestGenerateEntry(t *testing.T) {
 	assert.NotNil(t, k2v, v2k)
 	defer k2v.Close()
 	defer v2k.Close()
+	s := &BadgerStore{K2v: k2v, V2k: v2k}
 
 	type Test struct {
 		Name             string
@@ -139,11 +199,11 @@ func TestGenerateEntry(t *testing.T) {
 			ExpectedEntryKey: "collision",
 			ExpectedError:    "Too many collisions on creating collision",
 			Setup: func() {
-				INT_MAX = 1
+				MAX_VALUE = 1
 				_WriteEntryHelper(k2v, v2k, "collision-before")
 			},
 			TearDown: func() {
-				INT_MAX = 9999999
+				MAX_VALUE = 999999999
 			},
 		},
 	}
@@ -151,7 +211,7 @@ func TestGenerateEntry(t *testing.T) {
 	for _, test := range testTable {
 		t.Run(test.Name, func(t *testing.T) {
 			test.Setup()
-			e, err := GenerateEntry(v2k, test.K)
+			e, err := s.GenerateEntry(test.K)
 			assert.Equal(t, test.ExpectedEntryKey, e.Key)
 			if err == nil {
 				assert.Equal(t, test.ExpectedError, "")
@@ -164,7 +224,7 @@ func TestGenerateEntry(t *testing.T) {
 }
 
 func TestCreateIfDoesntExist(t *testing.T) {
-	loadPath := "/tmp/twowaykv/" + strconv.Itoa(rand.Intn(INT_MAX))
+	loadPath := "/tmp/twowaykv/" + strconv.Itoa(rand.Int())
 	err := os.MkdirAll(loadPath, os.ModePerm)
 	defer os.RemoveAll(loadPath)
 	require.NoError(t, err)
@@ -178,6 +238,7 @@ func TestCreateIfDoesntExist(t *testing.T) {
 	assert.NotNil(t, k2v, v2k)
 	defer k2v.Close()
 	defer v2k.Close()
+	s := &BadgerStore{K2v: k2v, V2k: v2k}
 
 	type Test struct {
 		Name                  string
@@ -232,11 +293,9 @@ func TestCreateIfDoesntExist(t *testing.T) {
 	for _, test := range testTable {
 		t.Run(test.Name, func(t *testing.T) {
 			test.Setup()
-			entries, errors := CreateIfDoesntExist(
+			entries, errors := s.CreateIfDoesntExist(
 				test.Keys,
 				test.MuteAlreadyExists,
-				k2v,
-				v2k,
 			)
 			assert.Equal(t, test.ExpectedEntriesLength, len(entries))
 			assert.Equal(t, test.ExpectedErrors, errors)
@@ -245,7 +304,7 @@ func TestCreateIfDoesntExist(t *testing.T) {
 
 }
 
-func TestwriteEntryToDB(t *testing.T) {
+func TestWriteEntryToDB(t *testing.T) {
 	// setup, create DBs
 	os.Setenv("GRAPH_DB_STORE_DIR", testingDir)
 	k2v, v2k, err := ConnectToDb()
@@ -256,10 +315,9 @@ func TestwriteEntryToDB(t *testing.T) {
 	assert.NotNil(t, k2v, v2k)
 	defer k2v.Close()
 	defer v2k.Close()
-	k2vWB := k2v.NewWriteBatch()
-	v2kWB := v2k.NewWriteBatch()
-	defer k2vWB.Cancel()
-	defer v2kWB.Cancel()
+	s := &BadgerStore{K2v: k2v, V2k: v2k}
+	txns := s.newTxnPair(true)
+	defer txns.Discard()
 
 	type Test struct {
 		Name          string
@@ -282,7 +340,7 @@ func TestwriteEntryToDB(t *testing.T) {
 	for _, test := range testTable {
 		t.Run(test.Name, func(t *testing.T) {
 			test.Setup()
-			_, err := writeEntryToDB(v2k, k2vWB, v2kWB, test.Key)
+			_, err := s.writeEntryToDB(txns, test.Key, "", 0)
 			if err == nil {
 				assert.Equal(t, test.ExpectedError, "")
 			} else {
@@ -292,26 +350,39 @@ func TestwriteEntryToDB(t *testing.T) {
 		})
 	}
 
-	v2kWB.Flush()
-	k2vWB.Flush()
+	assert.Nil(t, txns.Commit())
 
 }
 
 func TestReadRandomEntries(t *testing.T) {
-	loadPath := "/tmp/twowaykv/" + strconv.Itoa(rand.Intn(INT_MAX))
+	loadPath := "/tmp/twowaykv/" + strconv.Itoa(rand.Int())
 	err := os.MkdirAll(loadPath, os.ModePerm)
 	defer os.RemoveAll(loadPath)
 	require.NoError(t, err)
 	// setup, create DBs
 	os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
-	k2v, v2k, err := ConnectToDb()
-	if err != nil {
-		t.Fatal(err)
+	s, err := NewBadgerStore()
+	require.Nil(t, err)
+	defer s.Close()
+
+	// imports entries with values 2 to n+1
+	importN := func(n int) {
+		entries := []Entry{}
+		for i := 0; i < n; i++ {
+			entries = append(entries, Entry{Key: fmt.Sprintf("TEST-KEY-%d", i), Value: int64(i + 2)})
+		}
+		_, errors := s.ImportEntries(entries)
+		require.Equal(t, []string{}, errors)
+	}
+	// deletes the entries imported by importN
+	deleteN := func(n int) {
+		values := []int64{}
+		for i := 0; i < n; i++ {
+			values = append(values, int64(i+2))
+		}
+		_, errors := s.DeleteEntries([]string{}, values)
+		require.Equal(t, 0, len(errors))
 	}
-	assert.Nil(t, err)
-	assert.NotNil(t, k2v, v2k)
-	defer k2v.Close()
-	defer v2k.Close()
 
 	type Test struct {
 		Name                  string
@@ -331,65 +402,22 @@ func TestReadRandomEntries(t *testing.T) {
 			n:                     3,
 			ExpectedEntriesLength: 3,
 			ExpectedError:         "",
-			Setup: func() {
-				err := v2k.Update(func(txn *badger.Txn) error {
-					for i := 0; i < 100; i++ {
-						if e := txn.Set([]byte(strconv.Itoa(i+2)), []byte("TEST-KEY")); e != nil {
-							return e
-						}
-					}
-					return nil
-				})
-				require.Nil(t, err)
-			},
-			TearDown: func() {
-
-				err := v2k.Update(func(txn *badger.Txn) error {
-					for i := 0; i < 100; i++ {
-						if e := txn.Delete([]byte(strconv.Itoa(i + 2))); e != nil {
-							return e
-						}
-					}
-					return nil
-				})
-				require.Nil(t, err)
-			},
+			Setup:                 func() { importN(100) },
+			TearDown:              func() { deleteN(100) },
+		},
+		Test{
+			Name:                  "get 5 random entries when there are 5 in db",
+			n:                     5,
+			ExpectedEntriesLength: 5,
+			ExpectedError:         "",
+			Setup:                 func() { importN(5) },
+			TearDown:              func() { deleteN(5) },
 		},
-		// Test{
-		//
-		// 	Name:                  "get 5 random entries when there are 5 in db",
-		// 	n:                     5,
-		// 	ExpectedEntriesLength: 5,
-		// 	ExpectedError:         "",
-		// 	Setup: func() {
-		// 		err := v2k.Update(func(txn *badger.Txn) error {
-		// 			for i := 0; i < 5; i++ {
-		// 				if e := txn.Set([]byte(strconv.Itoa(i+2)), []byte("TEST-KEY")); e != nil {
-		// 					return e
-		// 				}
-		// 			}
-		// 			return nil
-		// 		})
-		// 		require.Nil(t, err)
-		// 	},
-		// 	TearDown: func() {
-		//
-		// 		err := v2k.Update(func(txn *badger.Txn) error {
-		// 			for i := 0; i < 5; i++ {
-		// 				if e := txn.Delete([]byte(strconv.Itoa(i + 2))); e != nil {
-		// 					return e
-		// 				}
-		// 			}
-		// 			return nil
-		// 		})
-		// 		require.Nil(t, err)
-		// 	},
-		// },
-		Test{
-			Name:                  "returns error when there are not enough entries in DB",
+		Test{
+			Name:                  "returns no entries when DB is empty",
 			n:                     10,
 			ExpectedEntriesLength: 0,
-			ExpectedError:         "max collisions reached finding random entries",
+			ExpectedError:         "",
 			Setup:                 func() {},
 			TearDown:              func() {},
 		},
@@ -398,7 +426,7 @@ func TestReadRandomEntries(t *testing.T) {
 	for _, test := range testTable {
 		t.Run(test.Name, func(t *testing.T) {
 			test.Setup()
-			entries, err := readRandomEntries(v2k, test.n)
+			entries, err := s.ReadRandomEntries(test.n, RandomFilter{})
 			assert.Equal(t, test.ExpectedEntriesLength, len(entries))
 			if err == nil {
 				assert.Equal(t, test.ExpectedError, "")
@@ -407,7 +435,7 @@ func TestReadRandomEntries(t *testing.T) {
 			}
 			// run test twice, make sure different results
 			if test.ResultIsUnique {
-				entries2, _ := readRandomEntries(v2k, test.n)
+				entries2, _ := s.ReadRandomEntries(test.n, RandomFilter{})
 				assert.NotEqual(t, entries, entries2)
 			}
 
@@ -418,7 +446,7 @@ func TestReadRandomEntries(t *testing.T) {
 
 func TestGetEntriesFromKeys(t *testing.T) {
 	// setup, create DBs
-	loadPath := "/tmp/twowaykv/" + strconv.Itoa(rand.Intn(INT_MAX))
+	loadPath := "/tmp/twowaykv/" + strconv.Itoa(rand.Int())
 	err := os.MkdirAll(loadPath, os.ModePerm)
 	defer os.RemoveAll(loadPath)
 	require.NoError(t, err)
@@ -431,6 +459,7 @@ func TestGetEntriesFromKeys(t *testing.T) {
 	assert.NotNil(t, k2v, v2k)
 	defer k2v.Close()
 	defer v2k.Close()
+	s := &BadgerStore{K2v: k2v, V2k: v2k}
 
 	type Test struct {
 		Name                  string
@@ -449,7 +478,7 @@ func TestGetEntriesFromKeys(t *testing.T) {
 			ExpectedErrorsLength:  0,
 			Setup: func() {
 				err := k2v.Update(func(txn *badger.Txn) error {
-					if e := txn.Set([]byte("testKEY"), []byte("111")); e != nil {
+					if e := txn.Set([]byte("testKEY"), encodeValue(111)); e != nil {
 						return e
 					}
 					return nil
@@ -475,7 +504,7 @@ func TestGetEntriesFromKeys(t *testing.T) {
 			ExpectedErrorsLength:  1,
 			Setup: func() {
 				err := k2v.Update(func(txn *badger.Txn) error {
-					if e := txn.Set([]byte("testKEY1"), []byte("111")); e != nil {
+					if e := txn.Set([]byte("testKEY1"), encodeValue(111)); e != nil {
 						return e
 					}
 					return nil
@@ -498,7 +527,7 @@ func TestGetEntriesFromKeys(t *testing.T) {
 
 	for _, test := range testTable {
 		test.Setup()
-		entries, errors := GetEntriesFromKeys(k2v, test.Keys)
+		entries, errors := s.GetEntriesFromKeys(test.Keys)
 		assert.Equal(t, test.ExpectedEntriesLength, len(entries))
 		assert.Equal(t, test.ExpectedErrorsLength, len(errors))
 		test.TearDown()
@@ -508,7 +537,7 @@ func TestGetEntriesFromKeys(t *testing.T) {
 
 func TestGetEntriesFromValues(t *testing.T) {
 	// setup, create DBs
-	loadPath := "/tmp/twowaykv/" + strconv.Itoa(rand.Intn(INT_MAX))
+	loadPath := "/tmp/twowaykv/" + strconv.Itoa(rand.Int())
 	err := os.MkdirAll(loadPath, os.ModePerm)
 	defer os.RemoveAll(loadPath)
 	require.NoError(t, err)
@@ -521,10 +550,11 @@ func TestGetEntriesFromValues(t *testing.T) {
 	assert.NotNil(t, k2v, v2k)
 	defer k2v.Close()
 	defer v2k.Close()
+	s := &BadgerStore{K2v: k2v, V2k: v2k}
 
 	type Test struct {
 		Name                  string
-		Values                []int
+		Values                []int64
 		ExpectedEntriesLength int
 		ExpectedErrorsLength  int
 		Setup                 func()
@@ -534,12 +564,12 @@ func TestGetEntriesFromValues(t *testing.T) {
 	testTable := []Test{
 		Test{
 			Name:                  "retrieves entries given values",
-			Values:                []int{111},
+			Values:                []int64{111},
 			ExpectedEntriesLength: 1,
 			ExpectedErrorsLength:  0,
 			Setup: func() {
 				err := v2k.Update(func(txn *badger.Txn) error {
-					if e := txn.Set([]byte("111"), []byte("testKey")); e != nil {
+					if e := txn.Set(encodeValue(111), []byte("testKey")); e != nil {
 						return e
 					}
 					return nil
@@ -548,7 +578,7 @@ func TestGetEntriesFromValues(t *testing.T) {
 			},
 			TearDown: func() {
 				err := v2k.Update(func(txn *badger.Txn) error {
-					if e := txn.Delete([]byte("111")); e != nil {
+					if e := txn.Delete(encodeValue(111)); e != nil {
 						return e
 					}
 					return nil
@@ -558,12 +588,12 @@ func TestGetEntriesFromValues(t *testing.T) {
 		},
 		Test{
 			Name:                  "throws error if value doesnt exist",
-			Values:                []int{112, 113},
+			Values:                []int64{112, 113},
 			ExpectedEntriesLength: 1,
 			ExpectedErrorsLength:  1,
 			Setup: func() {
 				err := v2k.Update(func(txn *badger.Txn) error {
-					if e := txn.Set([]byte("112"), []byte("testKey")); e != nil {
+					if e := txn.Set(encodeValue(112), []byte("testKey")); e != nil {
 						return e
 					}
 					return nil
@@ -572,7 +602,7 @@ func TestGetEntriesFromValues(t *testing.T) {
 			},
 			TearDown: func() {
 				err := v2k.Update(func(txn *badger.Txn) error {
-					if e := txn.Delete([]byte("112")); e != nil {
+					if e := txn.Delete(encodeValue(112)); e != nil {
 						return e
 					}
 					return nil
@@ -585,7 +615,7 @@ func TestGetEntriesFromValues(t *testing.T) {
 
 	for _, test := range testTable {
 		test.Setup()
-		entries, errors := GetEntriesFromValues(v2k, test.Values)
+		entries, errors := s.GetEntriesFromValues(test.Values)
 		if test.ExpectedErrorsLength != len(errors) && len(errors) != 0 {
 			fmt.Println("------------------------------------------")
 			fmt.Println(errors)
@@ -599,7 +629,7 @@ func TestGetEntriesFromValues(t *testing.T) {
 func TestSeekWithPrefix(t *testing.T) {
 
 	// setup, create DBs
-	loadPath := "/tmp/twowaykv/" + strconv.Itoa(rand.Intn(INT_MAX))
+	loadPath := "/tmp/twowaykv/" + strconv.Itoa(rand.Int())
 	err := os.MkdirAll(loadPath, os.ModePerm)
 	defer os.RemoveAll(loadPath)
 	require.NoError(t, err)
@@ -612,6 +642,7 @@ func TestSeekWithPrefix(t *testing.T) {
 	assert.NotNil(t, k2v, v2k)
 	defer k2v.Close()
 	defer v2k.Close()
+	s := &BadgerStore{K2v: k2v, V2k: v2k}
 
 	type Test struct {
 		Name                  string
@@ -630,7 +661,7 @@ func TestSeekWithPrefix(t *testing.T) {
 			ExpectedErrorsLength:  0,
 			Setup: func() {
 				err := k2v.Update(func(txn *badger.Txn) error {
-					if e := txn.Set([]byte("keyToSearchFor"), []byte("111")); e != nil {
+					if e := txn.Set([]byte("keyToSearchFor"), encodeValue(111)); e != nil {
 						return e
 					}
 					return nil
@@ -655,11 +686,11 @@ func TestSeekWithPrefix(t *testing.T) {
 			ExpectedErrorsLength:  0,
 			Setup: func() {
 				err := k2v.Update(func(txn *badger.Txn) error {
-					if e := txn.Set([]byte("keyToSearchFor"), []byte("111")); e != nil {
+					if e := txn.Set([]byte("keyToSearchFor"), encodeValue(111)); e != nil {
 						return e
 					}
 					for i := 0; i < 1000; i++ {
-						if e := txn.Set([]byte(strconv.Itoa(i)), []byte("111")); e != nil {
+						if e := txn.Set([]byte(strconv.Itoa(i)), encodeValue(111)); e != nil {
 							return e
 						}
 					}
@@ -686,7 +717,7 @@ func TestSeekWithPrefix(t *testing.T) {
 			Setup: func() {
 				err := k2v.Update(func(txn *badger.Txn) error {
 					for i := 0; i < 1000; i++ {
-						if e := txn.Set([]byte("TESTPREFIX"+strconv.Itoa(i)), []byte("111")); e != nil {
+						if e := txn.Set([]byte("TESTPREFIX"+strconv.Itoa(i)), encodeValue(111)); e != nil {
 							return e
 						}
 					}
@@ -714,7 +745,7 @@ func TestSeekWithPrefix(t *testing.T) {
 			Setup: func() {
 				err := k2v.Update(func(txn *badger.Txn) error {
 					for i := 0; i < 1000; i++ {
-						if e := txn.Set([]byte("TESTPREFIX"+strconv.Itoa(i)), []byte("111")); e != nil {
+						if e := txn.Set([]byte("TESTPREFIX"+strconv.Itoa(i)), encodeValue(111)); e != nil {
 							return e
 						}
 					}
@@ -738,10 +769,1602 @@ func TestSeekWithPrefix(t *testing.T) {
 
 	for _, test := range testTable {
 		test.Setup()
-		entries, errors := SeekWithPrefix(k2v, test.Q)
+		entries, errors := s.SeekWithPrefix(test.Q, SearchCursor{}, MAX_QUERY_RESULTS)
 		assert.Equal(t, test.ExpectedEntriesLength, len(entries))
 		assert.Equal(t, test.ExpectedErrorsLength, len(errors))
 		test.TearDown()
 	}
 
 }
+
+func TestSingleLayout(t *testing.T) {
+	loadPath := "/tmp/twowaykv/single/" + strconv.Itoa(rand.Int())
+	err := os.MkdirAll(loadPath, os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(loadPath)
+	os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+	os.Setenv("GRAPH_DB_STORE_LAYOUT", "single")
+	defer os.Unsetenv("GRAPH_DB_STORE_LAYOUT")
+	s, err := NewBadgerStore()
+	require.Nil(t, err)
+	defer s.Close()
+	require.True(t, s.isSingle())
+
+	entries, errors := s.CreateIfDoesntExist([]string{"singleKey1", "singleKey2"}, false)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, []string{}, errors)
+
+	t.Run("writes both directions under prefixes", func(t *testing.T) {
+		err := s.K2v.View(func(txn *badger.Txn) error {
+			item, err := txn.Get([]byte("k/singleKey1"))
+			require.Nil(t, err)
+			v, _ := item.ValueCopy(nil)
+			assert.Equal(t, encodeValue(entries[0].Value), v)
+			item, err = txn.Get(append([]byte("v/"), encodeValue(entries[0].Value)...))
+			require.Nil(t, err)
+			k, _ := item.ValueCopy(nil)
+			assert.Equal(t, "singleKey1", string(k))
+			return nil
+		})
+		require.Nil(t, err)
+	})
+	t.Run("looks up entries in both directions", func(t *testing.T) {
+		found, errors := s.GetEntriesFromKeys([]string{"singleKey1", "singleKey2"})
+		assert.Equal(t, entries, found)
+		assert.Equal(t, 0, len(errors))
+		found, errors = s.GetEntriesFromValues([]int64{entries[0].Value, entries[1].Value})
+		assert.Equal(t, entries, found)
+		assert.Equal(t, 0, len(errors))
+	})
+	t.Run("does not mix up directions on search", func(t *testing.T) {
+		found, _ := s.SeekWithPrefix("single", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, entries, found)
+		found, _ = s.SeekWithPrefix("", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, entries, found)
+	})
+	t.Run("reads random entries", func(t *testing.T) {
+		found, err := s.ReadRandomEntries(1, RandomFilter{})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(found))
+		assert.Contains(t, entries, found[0])
+	})
+	t.Run("creates entries spanning several transactions", func(t *testing.T) {
+		TXN_BATCH_SIZE = 2
+		defer func() { TXN_BATCH_SIZE = 1000 }()
+		created, errors := s.CreateIfDoesntExist([]string{"batch1", "batch2", "batch3", "batch4", "batch5"}, false)
+		assert.Equal(t, 5, len(created))
+		assert.Equal(t, []string{}, errors)
+		found, errors := s.GetEntriesFromKeys([]string{"batch1", "batch5"})
+		assert.Equal(t, 2, len(found))
+		assert.Equal(t, 0, len(errors))
+	})
+	t.Run("only reports entries of committed batches", func(t *testing.T) {
+		txn := s.newTxnPair(true)
+		defer txn.Discard()
+		_, err := txn.k2v.Get(s.kKey("conflict"))
+		require.Equal(t, badger.ErrKeyNotFound, err)
+		e, err := s.writeEntryToDB(txn, "conflict", "", 0)
+		require.Nil(t, err)
+		existing := Entry{Key: "singleKey1", Value: entries[0].Value}
+		txn.checkpoint(existing, false)
+		require.Equal(t, 0, len(txn.checkpoint(e, true)))
+		// a concurrent write of the same key makes the commit conflict
+		_, errors := s.CreateIfDoesntExist([]string{"conflict"}, false)
+		require.Equal(t, []string{}, errors)
+		errors = txn.commitBatch()
+		assert.Equal(t, []string{"Could not write entry conflict: " + badger.ErrConflict.Error()}, errors)
+		assert.Equal(t, []Entry{existing}, txn.results)
+	})
+}
+
+func TestMigrateToSingleLayout(t *testing.T) {
+	loadPath := "/tmp/twowaykv/migrate/" + strconv.Itoa(rand.Int())
+	err := os.MkdirAll(loadPath, os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(loadPath)
+	os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+
+	// write entries in split layout
+	split, err := NewBadgerStore()
+	require.Nil(t, err)
+	entries, errors := split.CreateIfDoesntExist([]string{"migrate1", "migrate2", "migrate3"}, false)
+	require.Equal(t, []string{}, errors)
+	temporary, errors := split.CreateWithTTL([]string{"temporary"}, false, time.Hour)
+	require.Equal(t, []string{}, errors)
+	require.Nil(t, split.Close())
+
+	require.Nil(t, MigrateToSingleLayout())
+
+	os.Setenv("GRAPH_DB_STORE_LAYOUT", "single")
+	defer os.Unsetenv("GRAPH_DB_STORE_LAYOUT")
+	single, err := NewBadgerStore()
+	require.Nil(t, err)
+	found, errors := single.GetEntriesFromKeys([]string{"migrate1", "migrate2", "migrate3"})
+	assert.Equal(t, entries, found)
+	assert.Equal(t, 0, len(errors))
+	found, errors = single.GetEntriesFromValues([]int64{entries[0].Value, entries[1].Value, entries[2].Value})
+	assert.Equal(t, entries, found)
+	assert.Equal(t, 0, len(errors))
+	// keeps the expiry of both directions
+	single.K2v.View(func(txn *badger.Txn) error {
+		for _, k := range [][]byte{single.kKey("temporary"), single.vKey(temporary[0].Value)} {
+			item, err := txn.Get(k)
+			require.Nil(t, err)
+			assert.NotEqual(t, uint64(0), item.ExpiresAt())
+		}
+		return nil
+	})
+	require.Nil(t, single.Close())
+
+	// migrating again would overwrite newer entries
+	assert.EqualError(t, MigrateToSingleLayout(), loadPath+"/db already holds data, remove it before migrating again")
+}
+
+func TestConcurrentCreateIfDoesntExist(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertConcurrentCreatesAreUnique(t, s)
+	})
+}
+
+func TestMigrateValueEncoding(t *testing.T) {
+	for _, layout := range []string{LAYOUT_SPLIT, LAYOUT_SINGLE} {
+		t.Run(layout, func(t *testing.T) {
+			defer useTestStoreDir(t, layout)()
+
+			// write entries the way older versions did
+			var err error
+			old := &BadgerStore{}
+			if layout == LAYOUT_SINGLE {
+				db, err := ConnectToSingleDb()
+				require.Nil(t, err)
+				old = NewSingleBadgerStore(db)
+			} else {
+				old.K2v, old.V2k, err = ConnectToDb()
+				require.Nil(t, err)
+			}
+			oldEntries := []Entry{Entry{Key: "nine", Value: 9}, Entry{Key: "ten", Value: 10}, Entry{Key: "hundred", Value: 100}}
+			err = old.K2v.Update(func(txn *badger.Txn) error {
+				for _, e := range oldEntries {
+					if err := txn.Set(old.kKey(e.Key), []byte(strconv.FormatInt(e.Value, 10))); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			require.Nil(t, err)
+			err = old.V2k.Update(func(txn *badger.Txn) error {
+				for _, e := range oldEntries {
+					k := append(append([]byte{}, old.vPrefix...), strconv.FormatInt(e.Value, 10)...)
+					if err := txn.Set(k, []byte(e.Key)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			require.Nil(t, err)
+			require.Nil(t, old.Close())
+
+			// migrates on startup
+			s, err := NewBadgerStore()
+			require.Nil(t, err)
+			found, errors := s.GetEntriesFromKeys([]string{"nine", "ten", "hundred"})
+			assert.Equal(t, oldEntries, found)
+			assert.Equal(t, 0, len(errors))
+			found, errors = s.GetEntriesFromValues([]int64{9, 10, 100})
+			assert.Equal(t, oldEntries, found)
+			assert.Equal(t, 0, len(errors))
+			// v2k is ordered numerically
+			values := []int64{}
+			err = s.V2k.View(func(txn *badger.Txn) error {
+				it := txn.NewIterator(badger.DefaultIteratorOptions)
+				defer it.Close()
+				for it.Seek(s.vKey(MIN_VALUE)); it.ValidForPrefix(s.vPrefix); it.Next() {
+					if v, err := s.parseVKey(it.Item().Key()); err == nil {
+						values = append(values, v)
+					}
+				}
+				return nil
+			})
+			require.Nil(t, err)
+			assert.Equal(t, []int64{9, 10, 100}, values)
+			require.Nil(t, s.Close())
+
+			// second startup leaves migrated entries alone
+			s, err = NewBadgerStore()
+			require.Nil(t, err)
+			defer s.Close()
+			found, errors = s.GetEntriesFromValues([]int64{9, 10, 100})
+			assert.Equal(t, oldEntries, found)
+			assert.Equal(t, 0, len(errors))
+		})
+	}
+}
+
+func TestDeleteEntries(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertDeleteEntries(t, s)
+	})
+}
+
+// deletes entries by key and value and asserts both directions are gone
+func _AssertDeleteEntries(t *testing.T, s Store) {
+	created, errors := s.CreateIfDoesntExist([]string{"del1", "del2", "del3", "keep"}, false)
+	require.Equal(t, []string{}, errors)
+
+	type Test struct {
+		Name            string
+		Keys            []string
+		Values          []int64
+		ExpectedEntries []Entry
+		ExpectedErrors  []string
+	}
+	testTable := []Test{
+		Test{
+			Name:            "deletes by key",
+			Keys:            []string{"del1"},
+			ExpectedEntries: []Entry{created[0]},
+			ExpectedErrors:  []string{},
+		},
+		Test{
+			Name:            "deletes by value",
+			Values:          []int64{created[1].Value},
+			ExpectedEntries: []Entry{created[1]},
+			ExpectedErrors:  []string{},
+		},
+		Test{
+			Name:            "deletes entry passed by key and value once",
+			Keys:            []string{"del3"},
+			Values:          []int64{created[2].Value},
+			ExpectedEntries: []Entry{created[2]},
+			ExpectedErrors:  []string{},
+		},
+		Test{
+			Name:            "reports entries which don't exist",
+			Keys:            []string{"del1"},
+			Values:          []int64{created[1].Value},
+			ExpectedEntries: []Entry{},
+			ExpectedErrors: []string{
+				"Could not delete entry from key del1: Key not found",
+				fmt.Sprintf("Could not delete entry from value %d: Key not found", created[1].Value),
+			},
+		},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			entries, errors := s.DeleteEntries(test.Keys, test.Values)
+			assert.Equal(t, test.ExpectedEntries, entries)
+			assert.Equal(t, test.ExpectedErrors, errors)
+		})
+	}
+
+	t.Run("removes both directions", func(t *testing.T) {
+		found, errors := s.GetEntriesFromKeys([]string{"del1", "del2", "del3", "keep"})
+		assert.Equal(t, []Entry{created[3]}, found)
+		assert.Equal(t, 3, len(errors))
+		found, errors = s.GetEntriesFromValues([]int64{created[0].Value, created[1].Value, created[2].Value, created[3].Value})
+		assert.Equal(t, []Entry{created[3]}, found)
+		assert.Equal(t, 3, len(errors))
+	})
+}
+
+func TestRenameEntries(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertRenameEntries(t, s)
+	})
+}
+
+// renames entries and asserts values are kept in both directions
+func _AssertRenameEntries(t *testing.T, s Store) {
+	created, errors := s.CreateIfDoesntExist([]string{"old1", "old2", "taken"}, false)
+	require.Equal(t, []string{}, errors)
+
+	type Test struct {
+		Name            string
+		Renames         []Rename
+		ExpectedEntries []Entry
+		ExpectedErrors  []string
+	}
+	testTable := []Test{
+		Test{
+			Name:            "renames single key",
+			Renames:         []Rename{Rename{"old1", "new1"}},
+			ExpectedEntries: []Entry{Entry{Key: "new1", Value: created[0].Value}},
+			ExpectedErrors:  []string{},
+		},
+		Test{
+			Name:            "fails when new key already exists",
+			Renames:         []Rename{Rename{"old2", "taken"}},
+			ExpectedEntries: []Entry{},
+			ExpectedErrors:  []string{"Could not rename key old2: Key taken already exists in DB"},
+		},
+		Test{
+			Name:            "renames in batch form",
+			Renames:         []Rename{Rename{"old1", "x"}, Rename{"old2", "new2"}},
+			ExpectedEntries: []Entry{Entry{Key: "new2", Value: created[1].Value}},
+			ExpectedErrors:  []string{"Could not rename key old1: Key not found"},
+		},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			entries, errors := s.RenameEntries(test.Renames)
+			assert.Equal(t, test.ExpectedEntries, entries)
+			assert.Equal(t, test.ExpectedErrors, errors)
+		})
+	}
+
+	t.Run("updates both directions", func(t *testing.T) {
+		found, errors := s.GetEntriesFromKeys([]string{"old1", "old2"})
+		assert.Equal(t, 0, len(found))
+		assert.Equal(t, 2, len(errors))
+		found, errors = s.GetEntriesFromValues([]int64{created[0].Value, created[1].Value, created[2].Value})
+		assert.Equal(t, []Entry{Entry{Key: "new1", Value: created[0].Value}, Entry{Key: "new2", Value: created[1].Value}, created[2]}, found)
+		assert.Equal(t, 0, len(errors))
+	})
+}
+
+func TestImportEntries(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertImportEntries(t, s)
+	})
+}
+
+// imports entries with explicit values and asserts conflicts are reported
+func _AssertImportEntries(t *testing.T, s Store) {
+	type Test struct {
+		Name            string
+		Entries         []Entry
+		ExpectedEntries []Entry
+		ExpectedErrors  []string
+	}
+	testTable := []Test{
+		Test{
+			Name:            "imports entries",
+			Entries:         []Entry{Entry{Key: "imp1", Value: 10}, Entry{Key: "imp2", Value: 20}},
+			ExpectedEntries: []Entry{Entry{Key: "imp1", Value: 10}, Entry{Key: "imp2", Value: 20}},
+			ExpectedErrors:  []string{},
+		},
+		Test{
+			Name:            "reports taken keys and values per entry",
+			Entries:         []Entry{Entry{Key: "imp1", Value: 30}, Entry{Key: "imp3", Value: 20}, Entry{Key: "imp4", Value: 40}},
+			ExpectedEntries: []Entry{Entry{Key: "imp4", Value: 40}},
+			ExpectedErrors: []string{
+				"Key imp1 already exists in DB",
+				"Value 20 already exists in DB",
+			},
+		},
+		Test{
+			Name:            "reports conflicts within the same request",
+			Entries:         []Entry{Entry{Key: "imp5", Value: 50}, Entry{Key: "imp6", Value: 50}},
+			ExpectedEntries: []Entry{Entry{Key: "imp5", Value: 50}},
+			ExpectedErrors:  []string{"Value 50 already exists in DB"},
+		},
+		Test{
+			Name:            "rejects values out of range",
+			Entries:         []Entry{Entry{Key: "imp7", Value: 0}},
+			ExpectedEntries: []Entry{},
+			ExpectedErrors:  []string{fmt.Sprintf("Value 0 is out of range [%d, %d]", MIN_VALUE, MAX_VALUE)},
+		},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			entries, errors := s.ImportEntries(test.Entries)
+			assert.Equal(t, test.ExpectedEntries, entries)
+			assert.Equal(t, test.ExpectedErrors, errors)
+		})
+	}
+
+	t.Run("writes both directions", func(t *testing.T) {
+		found, _ := s.GetEntriesFromKeys([]string{"imp1", "imp2", "imp4", "imp5"})
+		assert.Equal(t, []Entry{Entry{Key: "imp1", Value: 10}, Entry{Key: "imp2", Value: 20}, Entry{Key: "imp4", Value: 40}, Entry{Key: "imp5", Value: 50}}, found)
+		found, _ = s.GetEntriesFromValues([]int64{10, 20, 40, 50})
+		assert.Equal(t, []Entry{Entry{Key: "imp1", Value: 10}, Entry{Key: "imp2", Value: 20}, Entry{Key: "imp4", Value: 40}, Entry{Key: "imp5", Value: 50}}, found)
+	})
+}
+
+func TestAliases(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertAliases(t, s)
+	})
+}
+
+// adds, resolves, renames and removes aliases
+func _AssertAliases(t *testing.T, s Store) {
+	created, errors := s.CreateIfDoesntExist([]string{"Earth", "Mars"}, false)
+	require.Equal(t, []string{}, errors)
+	earth, mars := created[0].Value, created[1].Value
+
+	t.Run("adds aliases", func(t *testing.T) {
+		entries, errors := s.AddAliases([]Alias{
+			Alias{Alias: "Terra", Key: "Earth"},
+			Alias{Alias: "Gaia", Key: "Terra"},
+			Alias{Alias: "Mars", Key: "Earth"},
+			Alias{Alias: "Tellus", Key: "Venus"},
+		})
+		assert.Equal(t, []Entry{Entry{Key: "Terra", Value: earth}, Entry{Key: "Gaia", Value: earth}}, entries)
+		assert.Equal(t, []string{
+			"Could not add alias Mars: Key Mars already exists in DB",
+			"Could not add alias Tellus: Key not found",
+		}, errors)
+	})
+
+	t.Run("resolves aliases by key and search", func(t *testing.T) {
+		entries, _ := s.GetEntriesFromKeys([]string{"Terra", "Gaia"})
+		assert.Equal(t, []Entry{Entry{Key: "Terra", Value: earth}, Entry{Key: "Gaia", Value: earth}}, entries)
+		entries, _ = s.SeekWithPrefix("Te", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{Entry{Key: "Terra", Value: earth}}, entries)
+	})
+
+	t.Run("lists aliases by value", func(t *testing.T) {
+		entries, _ := s.GetEntriesFromValues([]int64{earth, mars})
+		assert.Equal(t, []Entry{
+			Entry{Key: "Earth", Value: earth, Aliases: []string{"Gaia", "Terra"}},
+			Entry{Key: "Mars", Value: mars},
+		}, entries)
+	})
+
+	t.Run("renames aliases and canonical keys", func(t *testing.T) {
+		_, errors := s.RenameEntries([]Rename{Rename{"Gaia", "Gaea"}, Rename{"Earth", "World"}})
+		assert.Equal(t, []string{}, errors)
+		entries, _ := s.GetEntriesFromValues([]int64{earth})
+		assert.Equal(t, []Entry{Entry{Key: "World", Value: earth, Aliases: []string{"Gaea", "Terra"}}}, entries)
+	})
+
+	t.Run("removes aliases", func(t *testing.T) {
+		entries, errors := s.RemoveAliases([]string{"Gaea", "World", "Venus"})
+		assert.Equal(t, []Entry{Entry{Key: "Gaea", Value: earth}}, entries)
+		assert.Equal(t, []string{
+			"Could not remove alias World: Key World is not an alias",
+			"Could not remove alias Venus: Key not found",
+		}, errors)
+		entries, _ = s.GetEntriesFromValues([]int64{earth})
+		assert.Equal(t, []Entry{Entry{Key: "World", Value: earth, Aliases: []string{"Terra"}}}, entries)
+	})
+
+	t.Run("deleting by alias removes entry and aliases", func(t *testing.T) {
+		entries, errors := s.DeleteEntries([]string{"Terra"}, nil)
+		assert.Equal(t, []Entry{Entry{Key: "World", Value: earth}}, entries)
+		assert.Equal(t, []string{}, errors)
+		found, _ := s.GetEntriesFromKeys([]string{"World", "Terra", "Mars"})
+		assert.Equal(t, []Entry{Entry{Key: "Mars", Value: mars}}, found)
+	})
+}
+
+func TestNamespaces(t *testing.T) {
+	for _, layout := range []string{LAYOUT_SPLIT, LAYOUT_SINGLE} {
+		t.Run(layout, func(t *testing.T) {
+			os.Setenv("GRAPH_DB_ID_ALLOCATION", ALLOCATION_SEQUENTIAL)
+			defer os.Unsetenv("GRAPH_DB_ID_ALLOCATION")
+			s, remove := newTestBadgerStore(t, layout)
+			defer remove()
+			_AssertNamespaces(t, s)
+
+			t.Run("hides namespaces from the default store", func(t *testing.T) {
+				entries, _ := s.SeekWithPrefix("", SearchCursor{}, MAX_QUERY_RESULTS)
+				assert.Equal(t, []Entry{Entry{Key: "shared", Value: 1}}, entries)
+				entries, err := s.ReadRandomEntries(1, RandomFilter{})
+				assert.Nil(t, err)
+				assert.Equal(t, []Entry{Entry{Key: "shared", Value: 1}}, entries)
+			})
+
+			t.Run("persists namespaces and their sequences", func(t *testing.T) {
+				require.Nil(t, s.Close())
+				s, err := NewBadgerStore()
+				require.Nil(t, err)
+				defer s.Close()
+				names, err := s.ListNamespaces()
+				assert.Nil(t, err)
+				assert.Equal(t, []string{"articles", "users"}, names)
+				ns, err := s.Namespace("articles")
+				require.Nil(t, err)
+				entries, errors := ns.CreateIfDoesntExist([]string{"next"}, false)
+				assert.Equal(t, []string{}, errors)
+				assert.Equal(t, []Entry{Entry{Key: "next", Value: 2}}, entries)
+			})
+		})
+	}
+}
+
+// creates namespaces and asserts their keys and values are independent
+func _AssertNamespaces(t *testing.T, s Store) {
+	t.Run("creates namespaces", func(t *testing.T) {
+		assert.Nil(t, s.CreateNamespace("users"))
+		assert.Nil(t, s.CreateNamespace("articles"))
+		assert.Equal(t, "Namespace users already exists", s.CreateNamespace("users").Error())
+		assert.Equal(t, "Namespace name must be 1 to 64 letters, digits, '-' or '_' but was 'a/b'", s.CreateNamespace("a/b").Error())
+		names, err := s.ListNamespaces()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"articles", "users"}, names)
+	})
+
+	t.Run("fails on missing namespaces", func(t *testing.T) {
+		_, err := s.Namespace("categories")
+		assert.Equal(t, "Namespace categories not found", err.Error())
+	})
+
+	t.Run("keeps keys and values separate", func(t *testing.T) {
+		users, err := s.Namespace("users")
+		require.Nil(t, err)
+		articles, err := s.Namespace("articles")
+		require.Nil(t, err)
+		for _, store := range []Store{s, users, articles} {
+			entries, errors := store.CreateIfDoesntExist([]string{"shared"}, false)
+			assert.Equal(t, []string{}, errors)
+			assert.Equal(t, []Entry{Entry{Key: "shared", Value: 1}}, entries)
+		}
+		entries, errors := users.CreateIfDoesntExist([]string{"user"}, false)
+		assert.Equal(t, []string{}, errors)
+		assert.Equal(t, []Entry{Entry{Key: "user", Value: 2}}, entries)
+		found, _ := s.GetEntriesFromKeys([]string{"user"})
+		assert.Equal(t, 0, len(found))
+		found, _ = articles.GetEntriesFromValues([]int64{2})
+		assert.Equal(t, 0, len(found))
+		found, _ = users.SeekWithPrefix("", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{Entry{Key: "shared", Value: 1}, Entry{Key: "user", Value: 2}}, found)
+	})
+
+	t.Run("rejects keys addressing bookkeeping or other namespaces", func(t *testing.T) {
+		users, err := s.Namespace("users")
+		require.Nil(t, err)
+		injected := "\xff" + NAMESPACE_PREFIX + "users/k/foo"
+		expected := []string{fmt.Sprintf("Key %q is not valid UTF-8", injected)}
+		_, errors := s.RenameEntries([]Rename{Rename{From: "shared", To: injected}})
+		assert.Equal(t, expected, errors)
+		_, errors = s.CreateIfDoesntExist([]string{injected}, false)
+		assert.Equal(t, expected, errors)
+		_, errors = s.AddAliases([]Alias{Alias{Alias: injected, Key: "shared"}})
+		assert.Equal(t, expected, errors)
+		_, errors = s.ImportEntries([]Entry{Entry{Key: injected, Value: 100}})
+		assert.Equal(t, expected, errors)
+		_, errors = s.DeleteEntries([]string{injected}, []int64{})
+		assert.Equal(t, expected, errors)
+		_, errors = s.GetEntriesFromKeys([]string{injected})
+		assert.Equal(t, expected, errors)
+		found, _ := users.GetEntriesFromKeys([]string{"foo"})
+		assert.Equal(t, 0, len(found))
+		found, _ = s.GetEntriesFromKeys([]string{"shared"})
+		assert.Equal(t, []Entry{Entry{Key: "shared", Value: 1}}, found)
+	})
+
+	t.Run("can't nest namespaces", func(t *testing.T) {
+		users, err := s.Namespace("users")
+		require.Nil(t, err)
+		assert.Equal(t, ErrNestedNamespace, users.CreateNamespace("nested"))
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertMetadata(t, s)
+	})
+}
+
+// stores, updates and clears metadata, asserting values are unchanged
+func _AssertMetadata(t *testing.T, s Store) {
+	created, errors := s.CreateIfDoesntExist([]string{"plain"}, false)
+	require.Equal(t, []string{}, errors)
+	imported, errors := s.ImportEntries([]Entry{
+		Entry{Key: "imported", Value: 42, Metadata: json.RawMessage(`{"source": "legacy"}`)},
+	})
+	require.Equal(t, []string{}, errors)
+	assert.Equal(t, json.RawMessage(`{"source":"legacy"}`), imported[0].Metadata)
+
+	t.Run("returns stored metadata", func(t *testing.T) {
+		entries, errors := s.WithMetadata([]Entry{created[0], Entry{Key: "imported", Value: 42}})
+		assert.Equal(t, []string{}, errors)
+		assert.Equal(t, []Entry{
+			created[0],
+			Entry{Key: "imported", Value: 42, Metadata: json.RawMessage(`{"source":"legacy"}`)},
+		}, entries)
+	})
+
+	t.Run("updates metadata without changing values", func(t *testing.T) {
+		entries, errors := s.UpdateMetadata([]MetadataUpdate{
+			MetadataUpdate{Key: "plain", Metadata: json.RawMessage(`{"labels": ["a", "b"]}`)},
+			MetadataUpdate{Key: "imported", Metadata: json.RawMessage(`null`)},
+			MetadataUpdate{Key: "missing", Metadata: json.RawMessage(`{}`)},
+			MetadataUpdate{Key: "plain", Metadata: json.RawMessage(`[1, 2]`)},
+		})
+		assert.Equal(t, []Entry{
+			Entry{Key: "plain", Value: created[0].Value, Metadata: json.RawMessage(`{"labels":["a","b"]}`)},
+			Entry{Key: "imported", Value: 42},
+		}, entries)
+		assert.Equal(t, []string{
+			"Could not update metadata of key missing: Key not found",
+			"Metadata of plain must be a JSON object",
+		}, errors)
+		entries, _ = s.GetEntriesFromKeys([]string{"plain", "imported"})
+		entries, _ = s.WithMetadata(entries)
+		assert.Equal(t, []Entry{
+			Entry{Key: "plain", Value: created[0].Value, Metadata: json.RawMessage(`{"labels":["a","b"]}`)},
+			Entry{Key: "imported", Value: 42},
+		}, entries)
+	})
+
+	t.Run("deletes metadata with the entry", func(t *testing.T) {
+		_, errors := s.DeleteEntries([]string{"plain"}, nil)
+		assert.Equal(t, []string{}, errors)
+		entries, _ := s.ImportEntries([]Entry{Entry{Key: "plain", Value: created[0].Value}})
+		entries, _ = s.WithMetadata(entries)
+		assert.Equal(t, []Entry{Entry{Key: "plain", Value: created[0].Value}}, entries)
+	})
+}
+
+func TestListCreated(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertListCreated(t, s)
+	})
+}
+
+// creates entries one second apart and pages through them by creation time
+func _AssertListCreated(t *testing.T, s Store) {
+	start := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := start
+	now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	defer func() { now = time.Now }()
+	at := func(seconds int) *time.Time {
+		t := start.Add(time.Duration(seconds) * time.Second)
+		return &t
+	}
+
+	created, errors := s.CreateIfDoesntExist([]string{"a", "b", "c"}, false)
+	require.Equal(t, []string{}, errors)
+	_, errors = s.ImportEntries([]Entry{Entry{Key: "d", Value: 7}})
+	require.Equal(t, []string{}, errors)
+	a := Entry{Key: "a", Value: created[0].Value, Created: at(1)}
+	b := Entry{Key: "b", Value: created[1].Value, Created: at(2)}
+	c := Entry{Key: "c", Value: created[2].Value, Created: at(3)}
+	d := Entry{Key: "d", Value: 7, Created: at(4)}
+
+	t.Run("pages through all entries", func(t *testing.T) {
+		entries, errors := s.ListCreated(createdAfter(time.Time{}), 2)
+		assert.Equal(t, []string{}, errors)
+		assert.Equal(t, []Entry{a, b}, entries)
+		entries, errors = s.ListCreated(createdCursorOf(entries[1]), 2)
+		assert.Equal(t, []string{}, errors)
+		assert.Equal(t, []Entry{c, d}, entries)
+		entries, _ = s.ListCreated(createdCursorOf(entries[1]), 2)
+		assert.Equal(t, []Entry{}, entries)
+	})
+
+	t.Run("lists entries created after a time", func(t *testing.T) {
+		entries, _ := s.ListCreated(createdAfter(*at(2)), 10)
+		assert.Equal(t, []Entry{c, d}, entries)
+	})
+
+	t.Run("drops deleted entries and keeps renamed ones", func(t *testing.T) {
+		s.DeleteEntries([]string{"c"}, nil)
+		s.RenameEntries([]Rename{Rename{"b", "e"}})
+		entries, _ := s.ListCreated(createdAfter(*at(1)), 10)
+		assert.Equal(t, []Entry{Entry{Key: "e", Value: b.Value, Created: b.Created}, d}, entries)
+	})
+}
+
+func TestTTL(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertTTL(t, s)
+
+		t.Run("expires aliases with their entry", func(t *testing.T) {
+			_, errors := s.AddAliases([]Alias{Alias{Alias: "liveAlias", Key: "live"}})
+			require.Equal(t, []string{}, errors)
+			expiry := map[string]uint64{}
+			s.K2v.View(func(txn *badger.Txn) error {
+				for _, k := range []string{"live", "liveAlias"} {
+					item, err := txn.Get(s.kKey(k))
+					require.Nil(t, err)
+					expiry[k] = item.ExpiresAt()
+				}
+				return nil
+			})
+			assert.NotEqual(t, uint64(0), expiry["live"])
+			assert.Equal(t, expiry["live"], expiry["liveAlias"])
+		})
+	})
+}
+
+// creates entries an hour ago, some with a ttl which has passed since,
+// and asserts those are invisible
+func _AssertTTL(t *testing.T, s Store) {
+	now = func() time.Time { return time.Now().Add(-time.Hour) }
+	created, errors := s.CreateWithTTL([]string{"expired"}, false, time.Minute)
+	require.Equal(t, []string{}, errors)
+	expired := created[0]
+	created, errors = s.CreateWithTTL([]string{"live"}, false, 2*time.Hour)
+	require.Equal(t, []string{}, errors)
+	live := created[0]
+	created, errors = s.CreateIfDoesntExist([]string{"permanent"}, false)
+	require.Equal(t, []string{}, errors)
+	permanent := created[0]
+	now = time.Now
+
+	t.Run("hides expired entries from lookups", func(t *testing.T) {
+		entries, errors := s.GetEntriesFromKeys([]string{"expired", "live"})
+		assert.Equal(t, []Entry{live}, entries)
+		assert.Equal(t, 1, len(errors))
+		entries, errors = s.GetEntriesFromValues([]int64{expired.Value, permanent.Value})
+		assert.Equal(t, []Entry{permanent}, entries)
+		assert.Equal(t, 1, len(errors))
+	})
+
+	t.Run("hides expired entries from search", func(t *testing.T) {
+		entries, _ := s.SeekWithPrefix("", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{live, permanent}, entries)
+	})
+
+	t.Run("hides expired entries from random", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			entries, err := s.ReadRandomEntries(1, RandomFilter{})
+			assert.Nil(t, err)
+			assert.NotEqual(t, "expired", entries[0].Key)
+		}
+	})
+
+	t.Run("hides expired entries from listings", func(t *testing.T) {
+		entries, _ := s.ListCreated(createdAfter(time.Time{}), 10)
+		assert.Equal(t, 2, len(entries))
+	})
+
+	t.Run("creates expired keys again", func(t *testing.T) {
+		_, errors := s.CreateIfDoesntExist([]string{"expired"}, false)
+		assert.Equal(t, []string{}, errors)
+	})
+}
+
+func TestKeyNormalization(t *testing.T) {
+	os.Setenv("GRAPH_DB_KEY_NORMALIZATION", "nfc,fold,whitespace")
+	os.Setenv("GRAPH_DB_KEEP_DISPLAY_KEY", "true")
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertKeyNormalization(t, s)
+	})
+	os.Unsetenv("GRAPH_DB_KEY_NORMALIZATION")
+	os.Unsetenv("GRAPH_DB_KEEP_DISPLAY_KEY")
+
+	t.Run("fails on unknown normalization", func(t *testing.T) {
+		loadPath := "/tmp/twowaykv/normalization/" + strconv.Itoa(rand.Int())
+		err := os.MkdirAll(loadPath, os.ModePerm)
+		require.NoError(t, err)
+		defer os.RemoveAll(loadPath)
+		os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+		os.Setenv("GRAPH_DB_KEY_NORMALIZATION", "stem")
+		defer os.Unsetenv("GRAPH_DB_KEY_NORMALIZATION")
+		_, err = NewBadgerStore()
+		assert.EqualError(t, err, "Unknown key normalization 'stem'")
+	})
+
+	t.Run("normalizes existing keys when enabled", func(t *testing.T) {
+		loadPath := "/tmp/twowaykv/normalization/" + strconv.Itoa(rand.Int())
+		err := os.MkdirAll(loadPath, os.ModePerm)
+		require.NoError(t, err)
+		defer os.RemoveAll(loadPath)
+		os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+		s, err := NewBadgerStore()
+		require.Nil(t, err)
+		created, errors := s.CreateIfDoesntExist([]string{"Barack  Obama", "cheese"}, false)
+		require.Equal(t, []string{}, errors)
+		_, errors = s.AddAliases([]Alias{Alias{"BO", "Barack  Obama"}})
+		require.Equal(t, []string{}, errors)
+		require.Nil(t, s.CreateNamespace("people"))
+		ns, err := s.Namespace("people")
+		require.Nil(t, err)
+		_, errors = ns.ImportEntries([]Entry{Entry{Key: "Ada Lovelace", Value: 5}})
+		require.Equal(t, []string{}, errors)
+		s.Close()
+
+		os.Setenv("GRAPH_DB_KEY_NORMALIZATION", "fold,whitespace")
+		os.Setenv("GRAPH_DB_KEEP_DISPLAY_KEY", "true")
+		defer os.Unsetenv("GRAPH_DB_KEY_NORMALIZATION")
+		defer os.Unsetenv("GRAPH_DB_KEEP_DISPLAY_KEY")
+		s, err = NewBadgerStore()
+		require.Nil(t, err)
+		defer s.Close()
+		found, errors := s.GetEntriesFromKeys([]string{"BARACK OBAMA", "bo", "cheese"})
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, []Entry{
+			Entry{Key: "barack obama", Value: created[0].Value, Display: "Barack  Obama"},
+			Entry{Key: "bo", Value: created[0].Value},
+			Entry{Key: "cheese", Value: created[1].Value},
+		}, found)
+		// no duplicate next to the old key
+		entries, errors := s.CreateIfDoesntExist([]string{"Barack Obama"}, true)
+		assert.Equal(t, []string{}, errors)
+		assert.Equal(t, created[0].Value, entries[0].Value)
+		ns, err = s.Namespace("people")
+		require.Nil(t, err)
+		found, errors = ns.GetEntriesFromKeys([]string{"ada lovelace"})
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, 1, len(found))
+	})
+
+	t.Run("refuses to merge keys when enabled", func(t *testing.T) {
+		loadPath := "/tmp/twowaykv/normalization/" + strconv.Itoa(rand.Int())
+		err := os.MkdirAll(loadPath, os.ModePerm)
+		require.NoError(t, err)
+		defer os.RemoveAll(loadPath)
+		os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+		s, err := NewBadgerStore()
+		require.Nil(t, err)
+		_, errors := s.CreateIfDoesntExist([]string{"Cheese", "cheese"}, false)
+		require.Equal(t, []string{}, errors)
+		s.Close()
+
+		os.Setenv("GRAPH_DB_KEY_NORMALIZATION", "fold")
+		defer os.Unsetenv("GRAPH_DB_KEY_NORMALIZATION")
+		_, err = NewBadgerStore()
+		assert.EqualError(t, err, "Keys cheese and Cheese both normalize to cheese, rename one of them before setting GRAPH_DB_KEY_NORMALIZATION")
+		// nothing was renamed
+		os.Unsetenv("GRAPH_DB_KEY_NORMALIZATION")
+		s, err = NewBadgerStore()
+		require.Nil(t, err)
+		defer s.Close()
+		found, errors := s.GetEntriesFromKeys([]string{"Cheese", "cheese"})
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, 2, len(found))
+	})
+}
+
+// asserts keys differing in case, composition and whitespace resolve to
+// the same entry, which keeps the form it was created with
+func _AssertKeyNormalization(t *testing.T, s Store) {
+	created, errors := s.CreateIfDoesntExist([]string{"Barack  Obama "}, false)
+	require.Equal(t, []string{}, errors)
+	require.Equal(t, 1, len(created))
+	e := created[0]
+	assert.Equal(t, "barack obama", e.Key)
+	assert.Equal(t, "Barack  Obama ", e.Display)
+
+	t.Run("creates each normalized key once", func(t *testing.T) {
+		entries, errors := s.CreateIfDoesntExist([]string{"BARACK OBAMA", "barack obama"}, true)
+		assert.Equal(t, []string{}, errors)
+		assert.Equal(t, []Entry{
+			Entry{Key: "barack obama", Value: e.Value, Display: "Barack  Obama "},
+			Entry{Key: "barack obama", Value: e.Value, Display: "Barack  Obama "},
+		}, entries)
+	})
+
+	t.Run("doesn't keep display forms of normalized keys", func(t *testing.T) {
+		entries, errors := s.CreateIfDoesntExist([]string{"michelle obama"}, false)
+		assert.Equal(t, []string{}, errors)
+		require.Equal(t, 1, len(entries))
+		assert.Equal(t, "", entries[0].Display)
+	})
+
+	t.Run("looks up keys in any form", func(t *testing.T) {
+		entries, errors := s.GetEntriesFromKeys([]string{" Barack Obama", "barack\tOBAMA"})
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, []Entry{e}, entries)
+		entries, errors = s.GetEntriesFromValues([]int64{e.Value})
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, []Entry{e}, entries)
+	})
+
+	t.Run("searches prefixes in any form", func(t *testing.T) {
+		entries, _ := s.SeekWithPrefix("BARACK ", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{e}, entries)
+		entries, _ = s.SeekWithPrefix("BARACKO", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(entries))
+	})
+
+	t.Run("normalizes aliases", func(t *testing.T) {
+		entries, errors := s.AddAliases([]Alias{Alias{Alias: "POTUS 44", Key: "Barack Obama"}})
+		require.Equal(t, []string{}, errors)
+		assert.Equal(t, []Entry{Entry{Key: "potus 44", Value: e.Value}}, entries)
+		entries, _ = s.GetEntriesFromKeys([]string{"Potus  44"})
+		assert.Equal(t, []Entry{Entry{Key: "potus 44", Value: e.Value}}, entries)
+	})
+
+	t.Run("renames to the normalized key", func(t *testing.T) {
+		entries, errors := s.RenameEntries([]Rename{Rename{From: "BARACK OBAMA", To: "Barack H. Obama"}})
+		require.Equal(t, []string{}, errors)
+		assert.Equal(t, []Entry{Entry{Key: "barack h. obama", Value: e.Value, Display: "Barack H. Obama"}}, entries)
+		entries, _ = s.GetEntriesFromKeys([]string{"barack h. obama"})
+		assert.Equal(t, []Entry{Entry{Key: "barack h. obama", Value: e.Value, Display: "Barack H. Obama"}}, entries)
+	})
+
+	t.Run("deletes keys in any form", func(t *testing.T) {
+		entries, errors := s.DeleteEntries([]string{"BARACK H. OBAMA"}, []int64{})
+		assert.Equal(t, []string{}, errors)
+		assert.Equal(t, []Entry{Entry{Key: "barack h. obama", Value: e.Value}}, entries)
+		_, errors = s.GetEntriesFromKeys([]string{"potus 44"})
+		assert.Equal(t, 1, len(errors))
+	})
+}
+
+func TestSeekWithFoldedPrefix(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertSeekWithFoldedPrefix(t, s)
+
+		t.Run("indexes keys written before the index existed", func(t *testing.T) {
+			err := s.K2v.Update(func(txn *badger.Txn) error {
+				return txn.Set(s.kKey("Old Key"), encodeValue(12345))
+			})
+			require.Nil(t, err)
+			err = s.V2k.Update(func(txn *badger.Txn) error {
+				return txn.Delete(s.metaKey(FOLDED_INDEX_KEY))
+			})
+			require.Nil(t, err)
+			require.Nil(t, s.BuildIndexes())
+			entries, errors := s.SeekWithFoldedPrefix("old", SearchCursor{}, MAX_QUERY_RESULTS)
+			assert.Equal(t, 0, len(errors))
+			assert.Equal(t, []Entry{Entry{Key: "Old Key", Value: 12345}}, entries)
+		})
+	})
+}
+
+// asserts prefixes match keys in any case, and the index follows
+// renames, aliases and deletes
+func _AssertSeekWithFoldedPrefix(t *testing.T, s Store) {
+	created, errors := s.CreateIfDoesntExist([]string{"Barack Obama", "BARBARA", "bart", "Obama"}, false)
+	require.Equal(t, []string{}, errors)
+	values := map[string]int64{}
+	for _, e := range created {
+		values[e.Key] = e.Value
+	}
+
+	t.Run("matches keys in any case, ordered by folded key", func(t *testing.T) {
+		entries, errors := s.SeekWithFoldedPrefix("BAR", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, []Entry{
+			Entry{Key: "Barack Obama", Value: values["Barack Obama"]},
+			Entry{Key: "BARBARA", Value: values["BARBARA"]},
+			Entry{Key: "bart", Value: values["bart"]},
+		}, entries)
+	})
+
+	t.Run("leaves case sensitive search unchanged", func(t *testing.T) {
+		entries, _ := s.SeekWithPrefix("bar", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{Entry{Key: "bart", Value: values["bart"]}}, entries)
+	})
+
+	t.Run("follows renames, aliases and deletes", func(t *testing.T) {
+		_, errors := s.RenameEntries([]Rename{Rename{From: "bart", To: "Homer"}})
+		require.Equal(t, []string{}, errors)
+		_, errors = s.AddAliases([]Alias{Alias{Alias: "barry", Key: "Barack Obama"}})
+		require.Equal(t, []string{}, errors)
+		_, errors = s.DeleteEntries([]string{"BARBARA"}, []int64{})
+		require.Equal(t, []string{}, errors)
+		entries, _ := s.SeekWithFoldedPrefix("bar", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{
+			Entry{Key: "Barack Obama", Value: values["Barack Obama"]},
+			Entry{Key: "barry", Value: values["Barack Obama"]},
+		}, entries)
+		entries, _ = s.SeekWithFoldedPrefix("homer", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{Entry{Key: "Homer", Value: values["bart"]}}, entries)
+	})
+}
+
+func TestSearchContains(t *testing.T) {
+	os.Setenv("GRAPH_DB_TRIGRAM_INDEX", "true")
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertSearchContains(t, s)
+	})
+	os.Unsetenv("GRAPH_DB_TRIGRAM_INDEX")
+
+	t.Run("needs the trigram index", func(t *testing.T) {
+		loadPath := "/tmp/twowaykv/contains/" + strconv.Itoa(rand.Int())
+		err := os.MkdirAll(loadPath, os.ModePerm)
+		require.NoError(t, err)
+		defer os.RemoveAll(loadPath)
+		os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+		s, err := NewBadgerStore()
+		require.Nil(t, err)
+		defer s.Close()
+		entries, errors := s.SearchContains("obama", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{}, entries)
+		assert.Equal(t, []string{ErrNoTrigramIndex.Error()}, errors)
+	})
+
+	t.Run("rebuilds the index of existing stores and namespaces", func(t *testing.T) {
+		loadPath := "/tmp/twowaykv/contains/" + strconv.Itoa(rand.Int())
+		err := os.MkdirAll(loadPath, os.ModePerm)
+		require.NoError(t, err)
+		defer os.RemoveAll(loadPath)
+		os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+		s, err := NewBadgerStore()
+		require.Nil(t, err)
+		_, errors := s.CreateIfDoesntExist([]string{"Barack Obama"}, false)
+		require.Equal(t, []string{}, errors)
+		require.Nil(t, s.CreateNamespace("people"))
+		ns, err := s.Namespace("people")
+		require.Nil(t, err)
+		_, errors = ns.CreateIfDoesntExist([]string{"Michelle Obama"}, false)
+		require.Equal(t, []string{}, errors)
+		require.Nil(t, s.Close())
+
+		os.Setenv("GRAPH_DB_TRIGRAM_INDEX", "true")
+		defer os.Unsetenv("GRAPH_DB_TRIGRAM_INDEX")
+		require.Nil(t, RebuildAllIndexes())
+		s, err = NewBadgerStore()
+		require.Nil(t, err)
+		defer s.Close()
+		entries, errors := s.SearchContains("obama", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		require.Equal(t, 1, len(entries))
+		assert.Equal(t, "Barack Obama", entries[0].Key)
+		ns, err = s.Namespace("people")
+		require.Nil(t, err)
+		entries, errors = ns.SearchContains("obama", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		require.Equal(t, 1, len(entries))
+		assert.Equal(t, "Michelle Obama", entries[0].Key)
+	})
+
+	t.Run("builds the index when it is turned on", func(t *testing.T) {
+		loadPath := "/tmp/twowaykv/contains/" + strconv.Itoa(rand.Int())
+		err := os.MkdirAll(loadPath, os.ModePerm)
+		require.NoError(t, err)
+		defer os.RemoveAll(loadPath)
+		os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+		defer os.Unsetenv("GRAPH_DB_TRIGRAM_INDEX")
+		// reopens the store with or without trigram index
+		reopen := func(s *BadgerStore, trigrams string) *BadgerStore {
+			if s != nil {
+				require.Nil(t, s.Close())
+			}
+			os.Setenv("GRAPH_DB_TRIGRAM_INDEX", trigrams)
+			s, err := NewBadgerStore()
+			require.Nil(t, err)
+			return s
+		}
+		s := reopen(nil, "false")
+		_, errors := s.CreateIfDoesntExist([]string{"Barack Obama"}, false)
+		require.Equal(t, []string{}, errors)
+		s = reopen(s, "true")
+		entries, errors := s.SearchContains("obama", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, 1, len(entries))
+		// keys written while the index was off are indexed when it is back on
+		s = reopen(s, "false")
+		_, errors = s.CreateIfDoesntExist([]string{"Michelle Obama"}, false)
+		require.Equal(t, []string{}, errors)
+		s = reopen(s, "true")
+		defer s.Close()
+		entries, errors = s.SearchContains("obama", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, 2, len(entries))
+	})
+}
+
+// asserts substrings match keys in any case, ranked by position of the
+// match, and the index follows renames, aliases and deletes
+func _AssertSearchContains(t *testing.T, s Store) {
+	created, errors := s.CreateIfDoesntExist([]string{"Barack Obama", "Obama", "Michelle Obama", "Obamacare", "Bob"}, false)
+	require.Equal(t, []string{}, errors)
+	values := map[string]int64{}
+	for _, e := range created {
+		values[e.Key] = e.Value
+	}
+
+	t.Run("ranks matches in any case", func(t *testing.T) {
+		entries, errors := s.SearchContains("OBAMA", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, []Entry{
+			Entry{Key: "Obama", Value: values["Obama"]},
+			Entry{Key: "Obamacare", Value: values["Obamacare"]},
+			Entry{Key: "Barack Obama", Value: values["Barack Obama"]},
+			Entry{Key: "Michelle Obama", Value: values["Michelle Obama"]},
+		}, entries)
+	})
+
+	t.Run("matches queries shorter than a trigram", func(t *testing.T) {
+		entries, _ := s.SearchContains("ob", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 5, len(entries))
+		entries, _ = s.SearchContains("ma", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 4, len(entries))
+	})
+
+	t.Run("only matches whole queries", func(t *testing.T) {
+		entries, _ := s.SearchContains("obamas", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{}, entries)
+		entries, _ = s.SearchContains("ck oba", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{Entry{Key: "Barack Obama", Value: values["Barack Obama"]}}, entries)
+	})
+
+	t.Run("ranks only the first candidates in key order", func(t *testing.T) {
+		MAX_SEARCH_CANDIDATES = 2
+		defer func() { MAX_SEARCH_CANDIDATES = 10000 }()
+		entries, errors := s.SearchContains("obama", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []string{"Search for 'obama' matched more than 2 keys, only 2 of them were ranked"}, errors)
+		assert.Equal(t, []Entry{
+			Entry{Key: "Barack Obama", Value: values["Barack Obama"]},
+			Entry{Key: "Michelle Obama", Value: values["Michelle Obama"]},
+		}, entries)
+	})
+
+	t.Run("follows renames, aliases and deletes", func(t *testing.T) {
+		_, errors := s.RenameEntries([]Rename{Rename{From: "Obamacare", To: "Affordable Care Act"}})
+		require.Equal(t, []string{}, errors)
+		_, errors = s.AddAliases([]Alias{Alias{Alias: "FLOTUS", Key: "Michelle Obama"}})
+		require.Equal(t, []string{}, errors)
+		_, errors = s.DeleteEntries([]string{"Barack Obama"}, []int64{})
+		require.Equal(t, []string{}, errors)
+		entries, _ := s.SearchContains("obama", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{
+			Entry{Key: "Obama", Value: values["Obama"]},
+			Entry{Key: "Michelle Obama", Value: values["Michelle Obama"]},
+		}, entries)
+		entries, _ = s.SearchContains("care", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{Entry{Key: "Affordable Care Act", Value: values["Obamacare"]}}, entries)
+		entries, _ = s.SearchContains("lotu", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, []Entry{Entry{Key: "FLOTUS", Value: values["Michelle Obama"]}}, entries)
+	})
+}
+
+func TestSearchFuzzy(t *testing.T) {
+	os.Setenv("GRAPH_DB_TRIGRAM_INDEX", "true")
+	defer os.Unsetenv("GRAPH_DB_TRIGRAM_INDEX")
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertSearchFuzzy(t, s)
+
+		t.Run("compares only the first candidates", func(t *testing.T) {
+			MAX_SEARCH_CANDIDATES = 1
+			defer func() { MAX_SEARCH_CANDIDATES = 10000 }()
+			entries, errors := s.SearchFuzzy("Obama", 2, SearchCursor{}, MAX_QUERY_RESULTS)
+			assert.Equal(t, []string{"Fuzzy search for 'obama' found more than 1 candidates, only 1 of them were compared"}, errors)
+			assert.Equal(t, 1, len(entries))
+			assert.Equal(t, "Obama", entries[0].Key)
+		})
+	})
+}
+
+// asserts keys within the distance match in any case, closest first
+func _AssertSearchFuzzy(t *testing.T, s Store) {
+	created, errors := s.CreateIfDoesntExist([]string{"Barack Obama", "Obama", "Osama", "Obamacare", "Michelle Obama"}, false)
+	require.Equal(t, []string{}, errors)
+	values := map[string]int64{}
+	for _, e := range created {
+		values[e.Key] = e.Value
+	}
+
+	t.Run("matches misspellings, closest first", func(t *testing.T) {
+		entries, errors := s.SearchFuzzy("obamma", 1, SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, []Entry{Entry{Key: "Obama", Value: values["Obama"]}}, entries)
+		entries, errors = s.SearchFuzzy("OBAMA", 1, SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, []Entry{
+			Entry{Key: "Obama", Value: values["Obama"]},
+			Entry{Key: "Osama", Value: values["Osama"]},
+		}, entries)
+	})
+
+	t.Run("matches within larger distances", func(t *testing.T) {
+		entries, errors := s.SearchFuzzy("barak obamma", 2, SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, []Entry{Entry{Key: "Barack Obama", Value: values["Barack Obama"]}}, entries)
+	})
+
+	t.Run("doesn't match keys too far away", func(t *testing.T) {
+		entries, _ := s.SearchFuzzy("bush", 1, SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(entries))
+	})
+
+	t.Run("matches queries with few trigrams", func(t *testing.T) {
+		entries, errors := s.SearchFuzzy("Obama", 2, SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, []Entry{
+			Entry{Key: "Obama", Value: values["Obama"]},
+			Entry{Key: "Osama", Value: values["Osama"]},
+		}, entries)
+		entries, errors = s.SearchFuzzy("ob", 2, SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, 0, len(entries))
+	})
+}
+
+func TestSearchPagination(t *testing.T) {
+	os.Setenv("GRAPH_DB_TRIGRAM_INDEX", "true")
+	defer os.Unsetenv("GRAPH_DB_TRIGRAM_INDEX")
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		_AssertSearchPagination(t, s)
+	})
+}
+
+// asserts every mode of search can be walked page by page
+func _AssertSearchPagination(t *testing.T, s Store) {
+	keys := []string{"page-1", "Page-2", "page-3", "PAGE-4", "page-5", "other"}
+	_, errors := s.CreateIfDoesntExist(keys, false)
+	require.Equal(t, []string{}, errors)
+
+	// keys of all pages of size 2 of search
+	walk := func(search func(after SearchCursor, limit int) ([]Entry, []string)) (pages [][]string) {
+		after := SearchCursor{}
+		for len(pages) < 10 {
+			entries, errors := search(after, 2)
+			require.Equal(t, 0, len(errors))
+			page := []string{}
+			for _, e := range entries {
+				page = append(page, e.Key)
+			}
+			pages = append(pages, page)
+			if len(entries) < 2 {
+				return pages
+			}
+			after = after.next(entries)
+		}
+		return pages
+	}
+
+	t.Run("walks prefixes", func(t *testing.T) {
+		pages := walk(func(after SearchCursor, limit int) ([]Entry, []string) {
+			return s.SeekWithPrefix("page", after, limit)
+		})
+		assert.Equal(t, [][]string{[]string{"page-1", "page-3"}, []string{"page-5"}}, pages)
+	})
+
+	t.Run("walks prefixes ignoring case", func(t *testing.T) {
+		pages := walk(func(after SearchCursor, limit int) ([]Entry, []string) {
+			return s.SeekWithFoldedPrefix("PAGE", after, limit)
+		})
+		assert.Equal(t, [][]string{[]string{"page-1", "Page-2"}, []string{"page-3", "PAGE-4"}, []string{"page-5"}}, pages)
+	})
+
+	t.Run("walks ranked results", func(t *testing.T) {
+		pages := walk(func(after SearchCursor, limit int) ([]Entry, []string) {
+			return s.SearchContains("age-", after, limit)
+		})
+		assert.Equal(t, [][]string{[]string{"PAGE-4", "Page-2"}, []string{"page-1", "page-3"}, []string{"page-5"}}, pages)
+		pages = walk(func(after SearchCursor, limit int) ([]Entry, []string) {
+			return s.SearchFuzzy("page-0", 1, after, limit)
+		})
+		assert.Equal(t, [][]string{[]string{"PAGE-4", "Page-2"}, []string{"page-1", "page-3"}, []string{"page-5"}}, pages)
+	})
+
+	t.Run("continues ranked results after the cursor despite writes", func(t *testing.T) {
+		first, _ := s.SearchContains("age-", SearchCursor{}, 2)
+		require.Equal(t, 2, len(first))
+		after := SearchCursor{}.next(first)
+		// ranks before the cursor, and deletes the key of the cursor
+		_, errors := s.CreateIfDoesntExist([]string{"AGE-0"}, false)
+		require.Equal(t, []string{}, errors)
+		_, errors = s.DeleteEntries([]string{"Page-2"}, []int64{})
+		require.Equal(t, []string{}, errors)
+		entries, _ := s.SearchContains("age-", after, 2)
+		keys := []string{}
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+		assert.Equal(t, []string{"page-1", "page-3"}, keys)
+	})
+
+	t.Run("starts at the prefix for cursors before it", func(t *testing.T) {
+		entries, _ := s.SeekWithPrefix("page", SearchCursor{Key: "a"}, 1)
+		assert.Equal(t, "page-1", entries[0].Key)
+	})
+}
+
+func TestScanKeys(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		// keys of namespaces sort after all keys of the split layout
+		require.Nil(t, s.CreateNamespace("other"))
+		ns, err := s.Namespace("other")
+		require.Nil(t, err)
+		_, errors := ns.CreateIfDoesntExist([]string{"zzz"}, false)
+		require.Equal(t, []string{}, errors)
+		_AssertScanKeys(t, s)
+	})
+}
+
+// asserts ranges of keys are scanned in order, page by page
+func _AssertScanKeys(t *testing.T, s Store) {
+	created, errors := s.CreateIfDoesntExist([]string{"d", "b", "a", "c", "e"}, false)
+	require.Equal(t, []string{}, errors)
+	values := map[string]int64{}
+	for _, e := range created {
+		values[e.Key] = e.Value
+	}
+	// keys passed to f by a scan
+	scan := func(r KeyRange, after SearchCursor, limit int) (keys []string) {
+		err := s.ScanKeys(r, after, limit, func(e Entry) error {
+			assert.Equal(t, values[e.Key], e.Value)
+			keys = append(keys, e.Key)
+			return nil
+		})
+		require.Nil(t, err)
+		return keys
+	}
+
+	t.Run("scans all keys in order", func(t *testing.T) {
+		assert.Equal(t, []string{"a", "b", "c", "d", "e"}, scan(KeyRange{}, SearchCursor{}, 0))
+	})
+
+	t.Run("scans from start up to end", func(t *testing.T) {
+		assert.Equal(t, []string{"b", "c"}, scan(KeyRange{"b", "d"}, SearchCursor{}, 0))
+		assert.Equal(t, []string{"c", "d", "e"}, scan(KeyRange{Start: "bb"}, SearchCursor{}, 0))
+		assert.Equal(t, []string{"a"}, scan(KeyRange{End: "b"}, SearchCursor{}, 0))
+	})
+
+	t.Run("scans pages after cursors", func(t *testing.T) {
+		assert.Equal(t, []string{"a", "b"}, scan(KeyRange{}, SearchCursor{}, 2))
+		assert.Equal(t, []string{"c", "d"}, scan(KeyRange{}, SearchCursor{Key: "b"}, 2))
+		assert.Equal(t, []string{"e"}, scan(KeyRange{}, SearchCursor{Key: "d"}, 2))
+		assert.Equal(t, []string{"b", "c"}, scan(KeyRange{"b", "d"}, SearchCursor{Key: "a"}, 0))
+	})
+
+	t.Run("stops on errors of f", func(t *testing.T) {
+		n := 0
+		err := s.ScanKeys(KeyRange{}, SearchCursor{}, 0, func(e Entry) error {
+			if n++; n == 2 {
+				return fmt.Errorf("client went away")
+			}
+			return nil
+		})
+		assert.EqualError(t, err, "client went away")
+		assert.Equal(t, 2, n)
+	})
+}
+
+func TestScanValues(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		// bookkeeping and namespaces sort after all values
+		require.Nil(t, s.CreateNamespace("other"))
+		ns, err := s.Namespace("other")
+		require.Nil(t, err)
+		_, errors := ns.ImportEntries([]Entry{Entry{Key: "x", Value: 2}})
+		require.Equal(t, []string{}, errors)
+		_AssertScanValues(t, s)
+	})
+}
+
+// asserts ranges of values are scanned in numeric order
+func _AssertScanValues(t *testing.T, s Store) {
+	_, errors := s.ImportEntries([]Entry{
+		Entry{Key: "a", Value: 1000},
+		Entry{Key: "b", Value: 9},
+		Entry{Key: "c", Value: 256},
+		Entry{Key: "d", Value: 10},
+		Entry{Key: "e", Value: MAX_VALUE},
+	})
+	require.Equal(t, []string{}, errors)
+	// values passed to f by a scan
+	scan := func(r ValueRange, limit int) (values []int64) {
+		err := s.ScanValues(r, SearchCursor{}, limit, func(e Entry) error {
+			values = append(values, e.Value)
+			return nil
+		})
+		require.Nil(t, err)
+		return values
+	}
+
+	t.Run("scans values in numeric order", func(t *testing.T) {
+		assert.Equal(t, []int64{9, 10, 256, 1000, MAX_VALUE}, scan(ValueRange{MIN_VALUE, MAX_VALUE}, 0))
+	})
+
+	t.Run("scans between inclusive bounds", func(t *testing.T) {
+		assert.Equal(t, []int64{10, 256}, scan(ValueRange{10, 256}, 0))
+		assert.Equal(t, []int64{256, 1000}, scan(ValueRange{11, 999999}, 0))
+		assert.Equal(t, []int64(nil), scan(ValueRange{11, 12}, 0))
+	})
+
+	t.Run("scans up to limit", func(t *testing.T) {
+		assert.Equal(t, []int64{9, 10}, scan(ValueRange{MIN_VALUE, MAX_VALUE}, 2))
+	})
+
+	t.Run("continues after the value of the cursor", func(t *testing.T) {
+		values := []int64{}
+		err := s.ScanValues(ValueRange{MIN_VALUE, MAX_VALUE}, SearchCursor{Key: "d", Value: 10}, 2, func(e Entry) error {
+			values = append(values, e.Value)
+			return nil
+		})
+		require.Nil(t, err)
+		assert.Equal(t, []int64{256, 1000}, values)
+		err = s.ScanValues(ValueRange{MIN_VALUE, MAX_VALUE}, SearchCursor{Key: "e", Value: MAX_VALUE}, 0, func(e Entry) error {
+			return fmt.Errorf("Scanned %d after the end", e.Value)
+		})
+		assert.Nil(t, err)
+	})
+
+	t.Run("returns keys of values", func(t *testing.T) {
+		entries := []Entry{}
+		err := s.ScanValues(ValueRange{9, 9}, SearchCursor{}, 0, func(e Entry) error {
+			entries = append(entries, e)
+			return nil
+		})
+		require.Nil(t, err)
+		assert.Equal(t, []Entry{Entry{Key: "b", Value: 9}}, entries)
+	})
+}
+
+func TestReadRandomEntriesUniform(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		// entries of namespaces are never sampled
+		require.Nil(t, s.CreateNamespace("other"))
+		ns, err := s.Namespace("other")
+		require.Nil(t, err)
+		_, errors := ns.ImportEntries([]Entry{Entry{Key: "x", Value: 3}})
+		require.Equal(t, []string{}, errors)
+		_AssertReadRandomEntriesUniform(t, s)
+	})
+}
+
+// asserts every entry is sampled about as often, for dense and sparse values
+func _AssertReadRandomEntriesUniform(t *testing.T, s Store) {
+	testTable := [][]int64{
+		// dense
+		[]int64{1, 2, 3, 4, 5},
+		// large gaps before some values
+		[]int64{1, 2, 1000, 1001, MAX_VALUE},
+	}
+	for i, values := range testTable {
+		entries := []Entry{}
+		for _, v := range values {
+			entries = append(entries, Entry{Key: fmt.Sprintf("uniform%d-%d", i, v), Value: v})
+		}
+		_, errors := s.ImportEntries(entries)
+		require.Equal(t, []string{}, errors)
+
+		draws := 1000 * len(values)
+		counts := map[string]int{}
+		for d := 0; d < draws; d++ {
+			sampled, err := s.ReadRandomEntries(1, RandomFilter{})
+			require.Nil(t, err)
+			require.Equal(t, 1, len(sampled))
+			counts[sampled[0].Key]++
+		}
+		assert.Equal(t, len(values), len(counts))
+		for _, e := range entries {
+			assert.InDelta(t, 1000, counts[e.Key], 150, e.Key)
+		}
+		// never more than stored
+		sampled, err := s.ReadRandomEntries(len(values)+5, RandomFilter{})
+		require.Nil(t, err)
+		assert.Equal(t, len(values), len(sampled))
+
+		_, errors = s.DeleteEntries([]string{}, values)
+		require.Equal(t, 0, len(errors))
+	}
+}
+
+func TestOrdinalIndex(t *testing.T) {
+	s, remove := newTestBadgerStore(t, LAYOUT_SPLIT)
+	defer remove()
+	defer s.Close()
+
+	// values in the slots of the ordinal index, each slot must match the
+	// slot of its value
+	ordinals := func() (values []int64) {
+		err := s.V2k.View(func(txn *badger.Txn) error {
+			n, _, err := readEncoded(txn, s.metaKey(ORDINAL_COUNT_KEY))
+			require.Nil(t, err)
+			for i := int64(0); i < n; i++ {
+				v, found, err := readEncoded(txn, s.ordinalKey(i))
+				require.Nil(t, err)
+				require.True(t, found)
+				slot, found, err := readEncoded(txn, s.slotKey(v))
+				require.Nil(t, err)
+				require.True(t, found)
+				require.Equal(t, i, slot)
+				values = append(values, v)
+			}
+			return nil
+		})
+		require.Nil(t, err)
+		return values
+	}
+	_, errors := s.ImportEntries([]Entry{Entry{Key: "a", Value: 1}, Entry{Key: "b", Value: 2}, Entry{Key: "c", Value: 3}})
+	require.Equal(t, []string{}, errors)
+	created, errors := s.CreateIfDoesntExist([]string{"d"}, false)
+	require.Equal(t, []string{}, errors)
+	d := created[0].Value
+
+	t.Run("adds imported and created values", func(t *testing.T) {
+		assert.ElementsMatch(t, []int64{1, 2, 3, d}, ordinals())
+	})
+	t.Run("moves the last value into the slot of deleted values", func(t *testing.T) {
+		_, errors := s.DeleteEntries([]string{}, []int64{1})
+		require.Equal(t, 0, len(errors))
+		assert.ElementsMatch(t, []int64{2, 3, d}, ordinals())
+	})
+	t.Run("keeps the slot of renamed entries", func(t *testing.T) {
+		_, errors := s.RenameEntries([]Rename{Rename{From: "b", To: "bb"}})
+		require.Equal(t, []string{}, errors)
+		assert.ElementsMatch(t, []int64{2, 3, d}, ordinals())
+	})
+	t.Run("drops expired values once sampled", func(t *testing.T) {
+		now = func() time.Time { return time.Now().Add(-time.Hour) }
+		created, errors := s.CreateWithTTL([]string{"expired"}, false, time.Minute)
+		now = time.Now
+		require.Equal(t, []string{}, errors)
+		assert.ElementsMatch(t, []int64{2, 3, d, created[0].Value}, ordinals())
+		entries, err := s.ReadRandomEntries(10, RandomFilter{})
+		require.Nil(t, err)
+		assert.Equal(t, 3, len(entries))
+		assert.ElementsMatch(t, []int64{2, 3, d}, ordinals())
+	})
+	t.Run("is built for stores written before it existed", func(t *testing.T) {
+		for _, index := range []string{ORDINAL_KEY, SLOT_KEY, ORDINAL_COUNT_KEY, ORDINAL_INDEX_KEY} {
+			_, err := deleteWithPrefix(s.V2k, s.metaKey(index))
+			require.Nil(t, err)
+		}
+		assert.Equal(t, 0, len(ordinals()))
+		require.Nil(t, s.BuildIndexes())
+		assert.ElementsMatch(t, []int64{2, 3, d}, ordinals())
+		entries, err := s.ReadRandomEntries(10, RandomFilter{})
+		require.Nil(t, err)
+		assert.Equal(t, 3, len(entries))
+	})
+}
+
+func TestReadRandomEntriesFiltered(t *testing.T) {
+	forEachLayout(t, func(t *testing.T, s *BadgerStore) {
+		// entries of namespaces are never sampled
+		require.Nil(t, s.CreateNamespace("other"))
+		ns, err := s.Namespace("other")
+		require.Nil(t, err)
+		_, errors := ns.ImportEntries([]Entry{Entry{Key: "Category:X", Value: 4}})
+		require.Equal(t, []string{}, errors)
+		_AssertReadRandomEntriesFiltered(t, s)
+	})
+}
+
+// asserts only entries matching the filter are sampled, each about as often
+func _AssertReadRandomEntriesFiltered(t *testing.T, s Store) {
+	_, errors := s.ImportEntries([]Entry{
+		Entry{Key: "Category:A", Value: 1},
+		Entry{Key: "Category:B", Value: 2},
+		Entry{Key: "Category:C", Value: 1000000},
+		Entry{Key: "Other", Value: 3},
+	})
+	require.Equal(t, []string{}, errors)
+	// aliases don't count as entries of their own
+	_, errors = s.AddAliases([]Alias{Alias{"Category:AA", "Category:A"}, Alias{"Category:Other", "Other"}})
+	require.Equal(t, []string{}, errors)
+	_, errors = s.UpdateMetadata([]MetadataUpdate{
+		MetadataUpdate{"Category:B", json.RawMessage(`{"kind":"page","rank":2}`)},
+		MetadataUpdate{"Other", json.RawMessage(`{"kind":"page"}`)},
+	})
+	require.Equal(t, []string{}, errors)
+
+	type Test struct {
+		Name         string
+		Filter       RandomFilter
+		ExpectedKeys []string
+	}
+	testTable := []Test{
+		Test{
+			Name:         "samples keys with prefix",
+			Filter:       RandomFilter{Prefix: "Category:"},
+			ExpectedKeys: []string{"Category:A", "Category:B", "Category:C"},
+		},
+		Test{
+			Name:         "samples keys with metadata",
+			Filter:       RandomFilter{Metadata: map[string]interface{}{"kind": "page"}},
+			ExpectedKeys: []string{"Category:B", "Other"},
+		},
+		Test{
+			Name:         "samples keys with prefix and metadata",
+			Filter:       RandomFilter{Prefix: "Category:", Metadata: map[string]interface{}{"kind": "page", "rank": float64(2)}},
+			ExpectedKeys: []string{"Category:B"},
+		},
+		Test{
+			Name:         "samples nothing without matches",
+			Filter:       RandomFilter{Prefix: "Missing"},
+			ExpectedKeys: []string{},
+		},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			draws := 1000 * len(test.ExpectedKeys)
+			counts := map[string]int{}
+			for d := 0; d < draws; d++ {
+				sampled, err := s.ReadRandomEntries(1, test.Filter)
+				require.Nil(t, err)
+				require.Equal(t, 1, len(sampled))
+				counts[sampled[0].Key]++
+			}
+			assert.Equal(t, len(test.ExpectedKeys), len(counts))
+			for _, k := range test.ExpectedKeys {
+				assert.InDelta(t, 1000, counts[k], 150, k)
+			}
+			// all matches when asking for more
+			sampled, err := s.ReadRandomEntries(10, test.Filter)
+			require.Nil(t, err)
+			keys := []string{}
+			for _, e := range sampled {
+				keys = append(keys, e.Key)
+			}
+			assert.ElementsMatch(t, test.ExpectedKeys, keys)
+		})
+	}
+}
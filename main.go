@@ -26,10 +26,66 @@ func parseEnv() {
 	}
 
 	requiredEnvs := []string{
-		"GRAPH_DB_STORE_DIR",
 		"GRAPH_DB_STORE_PORT",
 		"GRAPH_DOCS_DIR",
 	}
+	// validate badger layout
+	switch os.Getenv("GRAPH_DB_STORE_LAYOUT") {
+	case "", LAYOUT_SPLIT, LAYOUT_SINGLE:
+	default:
+		logFatalf("GRAPH_DB_STORE_LAYOUT must be '%s' or '%s' but was '%s'", LAYOUT_SPLIT, LAYOUT_SINGLE, os.Getenv("GRAPH_DB_STORE_LAYOUT"))
+	}
+	// validate id allocation
+	switch os.Getenv("GRAPH_DB_ID_ALLOCATION") {
+	case "", ALLOCATION_RANDOM, ALLOCATION_SEQUENTIAL, ALLOCATION_HASH:
+	default:
+		logFatalf("GRAPH_DB_ID_ALLOCATION must be '%s', '%s' or '%s' but was '%s'", ALLOCATION_RANDOM, ALLOCATION_SEQUENTIAL, ALLOCATION_HASH, os.Getenv("GRAPH_DB_ID_ALLOCATION"))
+	}
+	// validate key normalization
+	if _, err := KeyNormalizerFromEnv(); err != nil {
+		logFatalf("GRAPH_DB_KEY_NORMALIZATION must be a comma separated list of '%s', '%s' or '%s': %v", NORMALIZE_FOLD, NORMALIZE_NFC, NORMALIZE_WHITESPACE, err)
+	}
+	// value bounds
+	for _, bound := range []struct {
+		env string
+		v   *int64
+	}{{"GRAPH_DB_MIN_VALUE", &MIN_VALUE}, {"GRAPH_DB_MAX_VALUE", &MAX_VALUE}} {
+		if os.Getenv(bound.env) == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(os.Getenv(bound.env), 10, 64)
+		if err != nil {
+			logFatalf(err.Error())
+		} else {
+			*bound.v = v
+			logMsg("%s=%d", bound.env, v)
+		}
+	}
+	// largest page of search results
+	if os.Getenv("GRAPH_DB_MAX_SEARCH_LIMIT") != "" {
+		limit, err := strconv.Atoi(os.Getenv("GRAPH_DB_MAX_SEARCH_LIMIT"))
+		if err != nil || limit < MAX_QUERY_RESULTS {
+			logFatalf("GRAPH_DB_MAX_SEARCH_LIMIT must be at least %d but was '%s'", MAX_QUERY_RESULTS, os.Getenv("GRAPH_DB_MAX_SEARCH_LIMIT"))
+		} else {
+			MAX_SEARCH_LIMIT = limit
+			logMsg("GRAPH_DB_MAX_SEARCH_LIMIT=%d", limit)
+		}
+	}
+	if MIN_VALUE < 0 || MIN_VALUE > MAX_VALUE {
+		logFatalf("GRAPH_DB_MIN_VALUE must be non-negative and at most GRAPH_DB_MAX_VALUE but was '%d'", MIN_VALUE)
+	}
+	if os.Getenv("GRAPH_DB_32BIT_VALUES") == "true" && MAX_VALUE > MAX_32BIT_VALUE {
+		logFatalf("GRAPH_DB_MAX_VALUE must be at most %d for 32 bit clients but was '%d'", MAX_32BIT_VALUE, MAX_VALUE)
+	}
+	// in memory store needs no storage directory
+	switch os.Getenv("GRAPH_DB_STORE_TYPE") {
+	case "", STORE_TYPE_BADGER:
+		requiredEnvs = append(requiredEnvs, "GRAPH_DB_STORE_DIR")
+	case STORE_TYPE_MEMORY:
+		logMsg("GRAPH_DB_STORE_TYPE=%s", STORE_TYPE_MEMORY)
+	default:
+		logFatalf("GRAPH_DB_STORE_TYPE must be '%s' or '%s' but was '%s'", STORE_TYPE_BADGER, STORE_TYPE_MEMORY, os.Getenv("GRAPH_DB_STORE_TYPE"))
+	}
 	for _, v := range requiredEnvs {
 		if os.Getenv(v) == "" {
 			logFatalf("'%s' was not set", v)
@@ -43,7 +99,7 @@ func parseEnv() {
 		logFatalf(err.Error())
 	}
 	if i < 1000 || i > 65535 {
-		logFatalf("GRAPH_DB_STORE_PORT must be a valid port in range but was '%i'", i)
+		logFatalf("GRAPH_DB_STORE_PORT must be a valid port in range but was '%d'", i)
 	}
 }
 
@@ -67,6 +123,27 @@ func main() {
 				return r.Run(fmt.Sprintf(":%s", port))
 			},
 		},
+		{
+			Name:    "migrate",
+			Aliases: []string{"m"},
+			Usage:   "copy the split k2v and v2k directories into the single layout",
+			Action: func(c *cli.Context) error {
+				if os.Getenv("GRAPH_DB_STORE_DIR") == "" {
+					logFatalf("'GRAPH_DB_STORE_DIR' was not set")
+				}
+				return MigrateToSingleLayout()
+			},
+		},
+		{
+			Name:  "rebuild-index",
+			Usage: "rebuild the search indexes of the store and all of its namespaces",
+			Action: func(c *cli.Context) error {
+				if os.Getenv("GRAPH_DB_STORE_DIR") == "" {
+					logFatalf("'GRAPH_DB_STORE_DIR' was not set")
+				}
+				return RebuildAllIndexes()
+			},
+		},
 	}
 
 	err := app.Run(os.Args)
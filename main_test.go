@@ -18,7 +18,7 @@ func TestParseEnv(t *testing.T) {
 	errors := []string{}
 	logFatalf = func(format string, args ...interface{}) {
 		if len(args) > 0 {
-			errors = append(errors, fmt.Sprintf(format, args))
+			errors = append(errors, fmt.Sprintf(format, args...))
 		} else {
 			errors = append(errors, format)
 		}
@@ -29,7 +29,7 @@ func TestParseEnv(t *testing.T) {
 	logs := []string{}
 	logMsg = func(format string, args ...interface{}) {
 		if len(args) > 0 {
-			logs = append(logs, fmt.Sprintf(format, args))
+			logs = append(logs, fmt.Sprintf(format, args...))
 		} else {
 			logs = append(logs, format)
 		}
@@ -78,14 +78,14 @@ func TestParseEnv(t *testing.T) {
 		parseEnv()
 		assert.Equal(t, 2, len(errors))
 		assert.Equal(t, "strconv.Atoi: parsing \"f232\": invalid syntax", errors[0])
-		assert.Equal(t, "GRAPH_DB_STORE_PORT must be a valid port in range but was '[%!i(int=0)]'", errors[1])
+		assert.Equal(t, "GRAPH_DB_STORE_PORT must be a valid port in range but was '0'", errors[1])
 	})
 	t.Run("fails if GRAPH_DB_STORE_PORT is not a positive int", func(t *testing.T) {
 		errors = []string{}
 		os.Setenv("GRAPH_DB_STORE_PORT", "-253")
 		parseEnv()
 		assert.Equal(t, 1, len(errors))
-		assert.Equal(t, "GRAPH_DB_STORE_PORT must be a valid port in range but was '[%!i(int=-253)]'", errors[0])
+		assert.Equal(t, "GRAPH_DB_STORE_PORT must be a valid port in range but was '-253'", errors[0])
 	})
 	t.Run("throws no errors if GRAPH_DB_STORE_PORT is '2534'", func(t *testing.T) {
 		errors = []string{}
@@ -93,4 +93,88 @@ func TestParseEnv(t *testing.T) {
 		parseEnv()
 		assert.Equal(t, 0, len(errors))
 	})
+	t.Run("does not require GRAPH_DB_STORE_DIR for memory store", func(t *testing.T) {
+		errors = []string{}
+		os.Setenv("GRAPH_DB_STORE_TYPE", "memory")
+		defer os.Unsetenv("GRAPH_DB_STORE_TYPE")
+		os.Unsetenv("GRAPH_DB_STORE_DIR")
+		defer os.Setenv("GRAPH_DB_STORE_DIR", "5")
+		parseEnv()
+		assert.Equal(t, 0, len(errors))
+	})
+	t.Run("fails on unknown GRAPH_DB_STORE_LAYOUT", func(t *testing.T) {
+		errors = []string{}
+		os.Setenv("GRAPH_DB_STORE_LAYOUT", "double")
+		defer os.Unsetenv("GRAPH_DB_STORE_LAYOUT")
+		parseEnv()
+		assert.Equal(t, []string{"GRAPH_DB_STORE_LAYOUT must be 'split' or 'single' but was 'double'"}, errors)
+	})
+	t.Run("fails on unknown GRAPH_DB_ID_ALLOCATION", func(t *testing.T) {
+		errors = []string{}
+		os.Setenv("GRAPH_DB_ID_ALLOCATION", "guess")
+		defer os.Unsetenv("GRAPH_DB_ID_ALLOCATION")
+		parseEnv()
+		assert.Equal(t, []string{"GRAPH_DB_ID_ALLOCATION must be 'random', 'sequential' or 'hash' but was 'guess'"}, errors)
+	})
+	t.Run("fails on too small GRAPH_DB_MAX_SEARCH_LIMIT", func(t *testing.T) {
+		errors = []string{}
+		os.Setenv("GRAPH_DB_MAX_SEARCH_LIMIT", "10")
+		defer os.Unsetenv("GRAPH_DB_MAX_SEARCH_LIMIT")
+		parseEnv()
+		assert.Equal(t, []string{"GRAPH_DB_MAX_SEARCH_LIMIT must be at least 25 but was '10'"}, errors)
+	})
+	t.Run("fails on unknown GRAPH_DB_KEY_NORMALIZATION", func(t *testing.T) {
+		errors = []string{}
+		os.Setenv("GRAPH_DB_KEY_NORMALIZATION", "fold,stem")
+		defer os.Unsetenv("GRAPH_DB_KEY_NORMALIZATION")
+		parseEnv()
+		assert.Equal(t, []string{"GRAPH_DB_KEY_NORMALIZATION must be a comma separated list of 'fold', 'nfc' or 'whitespace': Unknown key normalization 'stem'"}, errors)
+	})
+	t.Run("sets value bounds", func(t *testing.T) {
+		errors = []string{}
+		os.Setenv("GRAPH_DB_MIN_VALUE", "0")
+		os.Setenv("GRAPH_DB_MAX_VALUE", "9223372036854775807")
+		defer func() {
+			os.Unsetenv("GRAPH_DB_MIN_VALUE")
+			os.Unsetenv("GRAPH_DB_MAX_VALUE")
+			MIN_VALUE = 1
+			MAX_VALUE = 999999999
+		}()
+		parseEnv()
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, int64(0), MIN_VALUE)
+		assert.Equal(t, int64(9223372036854775807), MAX_VALUE)
+	})
+	t.Run("fails on inverted value bounds", func(t *testing.T) {
+		errors = []string{}
+		os.Setenv("GRAPH_DB_MIN_VALUE", "10")
+		os.Setenv("GRAPH_DB_MAX_VALUE", "5")
+		defer func() {
+			os.Unsetenv("GRAPH_DB_MIN_VALUE")
+			os.Unsetenv("GRAPH_DB_MAX_VALUE")
+			MIN_VALUE = 1
+			MAX_VALUE = 999999999
+		}()
+		parseEnv()
+		assert.Equal(t, []string{"GRAPH_DB_MIN_VALUE must be non-negative and at most GRAPH_DB_MAX_VALUE but was '10'"}, errors)
+	})
+	t.Run("fails on values too big for 32 bit clients", func(t *testing.T) {
+		errors = []string{}
+		os.Setenv("GRAPH_DB_MAX_VALUE", "2147483648")
+		os.Setenv("GRAPH_DB_32BIT_VALUES", "true")
+		defer func() {
+			os.Unsetenv("GRAPH_DB_MAX_VALUE")
+			os.Unsetenv("GRAPH_DB_32BIT_VALUES")
+			MAX_VALUE = 999999999
+		}()
+		parseEnv()
+		assert.Equal(t, []string{"GRAPH_DB_MAX_VALUE must be at most 2147483647 for 32 bit clients but was '2147483648'"}, errors)
+	})
+	t.Run("fails on unknown GRAPH_DB_STORE_TYPE", func(t *testing.T) {
+		errors = []string{}
+		os.Setenv("GRAPH_DB_STORE_TYPE", "postgres")
+		defer os.Unsetenv("GRAPH_DB_STORE_TYPE")
+		parseEnv()
+		assert.Equal(t, []string{"GRAPH_DB_STORE_TYPE must be 'badger' or 'memory' but was 'postgres'"}, errors)
+	})
 }
@@ -0,0 +1,743 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// returned by the memory store on lookups of missing keys and values
+var ErrNotFound = errors.New("Key not found")
+
+// in memory Store, keeping keys => values and values => keys
+// in two maps. Nothing is persisted to disk.
+type MemoryStore struct {
+	mu  sync.RWMutex
+	k2v map[string]int64
+	v2k map[int64]string
+	// extra keys in k2v resolving to each value
+	aliases map[int64]map[string]bool
+	// metadata of each value which has any
+	metadata map[int64]json.RawMessage
+	// creation time of each value
+	created map[int64]time.Time
+	// expiry of each value created with a ttl
+	expiry map[int64]time.Time
+	// original form of the key of each value whose key was normalized
+	display map[int64]string
+	// rewrites keys before they are stored or looked up
+	normalizer *KeyNormalizer
+	// how values of new keys are picked, see allocateValue
+	allocation string
+	// last value handed out by sequential allocation
+	sequence int64
+	// name of the namespace this store is, empty for the default store
+	namespace string
+	// namespaces by name, guarded by mu
+	namespaces map[string]*MemoryStore
+}
+
+// creates a new empty in memory store, allocating values as set by
+// GRAPH_DB_ID_ALLOCATION and normalizing keys as set by
+// GRAPH_DB_KEY_NORMALIZATION
+func NewMemoryStore() (*MemoryStore, error) {
+	normalizer, err := KeyNormalizerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryStore(normalizer, os.Getenv("GRAPH_DB_ID_ALLOCATION")), nil
+}
+
+// creates a new empty in memory store
+func newMemoryStore(normalizer *KeyNormalizer, allocation string) *MemoryStore {
+	return &MemoryStore{
+		k2v:        make(map[string]int64),
+		v2k:        make(map[int64]string),
+		aliases:    make(map[int64]map[string]bool),
+		metadata:   make(map[int64]json.RawMessage),
+		created:    make(map[int64]time.Time),
+		expiry:     make(map[int64]time.Time),
+		display:    make(map[int64]string),
+		normalizer: normalizer,
+		allocation: allocation,
+		namespaces: make(map[string]*MemoryStore),
+	}
+}
+
+// nothing to release
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// store of the namespace name
+func (s *MemoryStore) Namespace(name string) (Store, error) {
+	if s.namespace != "" {
+		return nil, ErrNestedNamespace
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ns, ok := s.namespaces[name]
+	if !ok {
+		return nil, namespaceNotFoundError(name)
+	}
+	return ns, nil
+}
+
+// adds a new, empty namespace
+func (s *MemoryStore) CreateNamespace(name string) error {
+	if s.namespace != "" {
+		return ErrNestedNamespace
+	}
+	if err := checkNamespaceName(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.namespaces[name]; ok {
+		return namespaceExistsError(name)
+	}
+	ns := newMemoryStore(s.normalizer, s.allocation)
+	ns.namespace = name
+	s.namespaces[name] = ns
+	return nil
+}
+
+// sorted names of all namespaces
+func (s *MemoryStore) ListNamespaces() (names []string, err error) {
+	names = []string{}
+	if s.namespace != "" {
+		return names, ErrNestedNamespace
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for name := range s.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// creates new Entry object to be written
+// assumed that key is not duplicate and that the write lock is held
+func (s *MemoryStore) GenerateEntry(k string) (Entry, error) {
+	v, err := allocateValue(s.allocation, k, s.nextSequential, func(v int64) (bool, error) {
+		_, taken := s.v2k[v]
+		return taken, nil
+	})
+	return Entry{Key: k, Value: v}, err
+}
+
+// next value of the sequence, starting at 0
+func (s *MemoryStore) nextSequential() (int64, error) {
+	s.sequence++
+	return s.sequence - 1, nil
+}
+
+// adds new entry to store if doesnt already exist
+// MuteAlreadyExists does not add errors to list if key already exists
+func (s *MemoryStore) CreateIfDoesntExist(
+	keys []string,
+	muteAlreadyExists bool,
+) (
+	entries []Entry,
+	errors []string,
+) {
+	return s.CreateWithTTL(keys, muteAlreadyExists, 0)
+}
+
+// same as CreateIfDoesntExist, new entries expire after ttl unless ttl
+// is 0. Existing entries keep their expiry.
+func (s *MemoryStore) CreateWithTTL(
+	keys []string,
+	muteAlreadyExists bool,
+	ttl time.Duration,
+) (
+	entries []Entry,
+	errors []string,
+) {
+	entries = []Entry{}
+	errors = []string{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpired()
+	for _, original := range keys {
+		k := s.normalizer.Normalize(original)
+		if err := checkKeys(k); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		if v, ok := s.k2v[k]; ok {
+			// key already exists in store
+			if !muteAlreadyExists {
+				errors = append(errors, fmt.Sprintf("Key %s already exists in DB", k))
+			}
+			entries = append(entries, Entry{Key: k, Value: v, Display: s.displayOf(k, v)})
+			continue
+		}
+		e, err := s.GenerateEntry(k)
+		if err != nil {
+			logErr("Could not create entry %+v: %v", e, err)
+			errors = append(errors, err.Error())
+			continue
+		}
+		e.Display = s.normalizer.Display(original, k)
+		s.setEntry(e)
+		if ttl != 0 {
+			s.expiry[e.Value] = now().Add(ttl)
+		}
+		entries = append(entries, e)
+	}
+	return entries, errors
+}
+
+// adds entries with values chosen by the client. Entries whose key or
+// value is already taken are not written and reported as errors.
+func (s *MemoryStore) ImportEntries(toImport []Entry) (entries []Entry, errors []string) {
+	entries = []Entry{}
+	errors = []string{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpired()
+	for _, e := range toImport {
+		original := e.Key
+		e.Key = s.normalizer.Normalize(original)
+		e.Display = s.normalizer.Display(original, e.Key)
+		if err := checkKeys(e.Key); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		if !valueInRange(e.Value) {
+			errors = append(errors, valueOutOfRangeError(e.Value).Error())
+			continue
+		}
+		if _, ok := s.k2v[e.Key]; ok {
+			errors = append(errors, fmt.Sprintf("Key %s already exists in DB", e.Key))
+			continue
+		}
+		if _, ok := s.v2k[e.Value]; ok {
+			errors = append(errors, fmt.Sprintf("Value %d already exists in DB", e.Value))
+			continue
+		}
+		m, err := checkMetadata(e.Key, e.Metadata)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		e.Metadata = m
+		s.setEntry(e)
+		entries = append(entries, e)
+	}
+	return entries, errors
+}
+
+// writes both directions of a new entry, its metadata, display form
+// and creation time. Assumes the write lock is held.
+func (s *MemoryStore) setEntry(e Entry) {
+	s.k2v[e.Key] = e.Value
+	s.v2k[e.Value] = e.Key
+	if e.Metadata != nil {
+		s.metadata[e.Value] = e.Metadata
+	}
+	if e.Display != "" {
+		s.display[e.Value] = e.Display
+	}
+	s.created[e.Value] = now().UTC()
+}
+
+// removes entries by key or by value from both maps
+func (s *MemoryStore) DeleteEntries(keys []string, values []int64) (entries []Entry, errors []string) {
+	entries = []Entry{}
+	errors = []string{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpired()
+	for _, k := range s.normalizer.NormalizeAll(keys) {
+		if err := checkKeys(k); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		v, ok := s.k2v[k]
+		if !ok {
+			errors = append(errors, fmt.Sprintf("Could not delete entry from key %s: %s", k, ErrNotFound.Error()))
+			continue
+		}
+		// aliases delete the entry they resolve to
+		entries = append(entries, Entry{Key: s.v2k[v], Value: v})
+		s.deleteEntry(v)
+	}
+	for _, v := range values {
+		k, ok := s.v2k[v]
+		if !ok {
+			// may have been deleted by key already
+			if !containsValue(entries, v) {
+				errors = append(errors, fmt.Sprintf("Could not delete entry from value %d: %s", v, ErrNotFound.Error()))
+			}
+			continue
+		}
+		s.deleteEntry(v)
+		entries = append(entries, Entry{Key: k, Value: v})
+	}
+	return entries, errors
+}
+
+// removes the entry with value v, its metadata, display form, creation
+// and expiry time and all of its aliases.
+// Assumes the write lock is held.
+func (s *MemoryStore) deleteEntry(v int64) {
+	for alias := range s.aliases[v] {
+		delete(s.k2v, alias)
+	}
+	delete(s.aliases, v)
+	delete(s.metadata, v)
+	delete(s.display, v)
+	delete(s.created, v)
+	delete(s.expiry, v)
+	delete(s.k2v, s.v2k[v])
+	delete(s.v2k, v)
+}
+
+// moves values from one key to another. Fails for renames onto keys
+// which already exist.
+func (s *MemoryStore) RenameEntries(renames []Rename) (entries []Entry, errors []string) {
+	entries = []Entry{}
+	errors = []string{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpired()
+	for _, r := range renames {
+		to := r.To
+		r = Rename{s.normalizer.Normalize(r.From), s.normalizer.Normalize(r.To)}
+		if err := checkKeys(r.From, r.To); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		v, ok := s.k2v[r.From]
+		if !ok {
+			errors = append(errors, fmt.Sprintf("Could not rename key %s: %s", r.From, ErrNotFound.Error()))
+			continue
+		}
+		if _, exists := s.k2v[r.To]; exists {
+			errors = append(errors, fmt.Sprintf("Could not rename key %s: Key %s already exists in DB", r.From, r.To))
+			continue
+		}
+		delete(s.k2v, r.From)
+		s.k2v[r.To] = v
+		e := Entry{Key: r.To, Value: v}
+		if s.aliases[v][r.From] {
+			// aliases stay aliases of the same entry
+			delete(s.aliases[v], r.From)
+			s.aliases[v][r.To] = true
+		} else {
+			s.v2k[v] = r.To
+			e.Display = s.normalizer.Display(to, r.To)
+			delete(s.display, v)
+			if e.Display != "" {
+				s.display[v] = e.Display
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, errors
+}
+
+// replaces the metadata of the entries of keys. Aliases update the
+// metadata of the entry they resolve to.
+func (s *MemoryStore) UpdateMetadata(updates []MetadataUpdate) (entries []Entry, errors []string) {
+	entries = []Entry{}
+	errors = []string{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpired()
+	for _, u := range updates {
+		u.Key = s.normalizer.Normalize(u.Key)
+		if err := checkKeys(u.Key); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		m, err := checkMetadata(u.Key, u.Metadata)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		v, ok := s.k2v[u.Key]
+		if !ok {
+			errors = append(errors, fmt.Sprintf("Could not update metadata of key %s: %s", u.Key, ErrNotFound.Error()))
+			continue
+		}
+		if m == nil {
+			delete(s.metadata, v)
+		} else {
+			s.metadata[v] = m
+		}
+		entries = append(entries, Entry{Key: u.Key, Value: v, Metadata: m})
+	}
+	return entries, errors
+}
+
+// sets the metadata of entries, entries without metadata are left without
+func (s *MemoryStore) WithMetadata(entries []Entry) ([]Entry, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i, e := range entries {
+		entries[i].Metadata = s.metadata[e.Value]
+	}
+	return entries, []string{}
+}
+
+// adds aliases resolving to the values of existing keys. Aliases can
+// be added to keys which are aliases themselves.
+func (s *MemoryStore) AddAliases(aliases []Alias) (entries []Entry, errors []string) {
+	entries = []Entry{}
+	errors = []string{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpired()
+	for _, a := range aliases {
+		a = Alias{s.normalizer.Normalize(a.Alias), s.normalizer.Normalize(a.Key)}
+		if err := checkKeys(a.Alias, a.Key); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		v, ok := s.k2v[a.Key]
+		if !ok {
+			errors = append(errors, fmt.Sprintf("Could not add alias %s: %s", a.Alias, ErrNotFound.Error()))
+			continue
+		}
+		if _, exists := s.k2v[a.Alias]; exists {
+			errors = append(errors, fmt.Sprintf("Could not add alias %s: Key %s already exists in DB", a.Alias, a.Alias))
+			continue
+		}
+		if s.aliases[v] == nil {
+			s.aliases[v] = make(map[string]bool)
+		}
+		s.aliases[v][a.Alias] = true
+		s.k2v[a.Alias] = v
+		entries = append(entries, Entry{Key: a.Alias, Value: v})
+	}
+	return entries, errors
+}
+
+// removes aliases, the entries they resolved to are kept
+func (s *MemoryStore) RemoveAliases(aliases []string) (entries []Entry, errors []string) {
+	entries = []Entry{}
+	errors = []string{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpired()
+	for _, alias := range s.normalizer.NormalizeAll(aliases) {
+		if err := checkKeys(alias); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		v, ok := s.k2v[alias]
+		if !ok {
+			errors = append(errors, fmt.Sprintf("Could not remove alias %s: %s", alias, ErrNotFound.Error()))
+			continue
+		}
+		if !s.aliases[v][alias] {
+			errors = append(errors, fmt.Sprintf("Could not remove alias %s: Key %s is not an alias", alias, alias))
+			continue
+		}
+		delete(s.aliases[v], alias)
+		delete(s.k2v, alias)
+		entries = append(entries, Entry{Key: alias, Value: v})
+	}
+	return entries, errors
+}
+
+// sorted aliases of the entry with value v
+func (s *MemoryStore) aliasesOf(v int64) (aliases []string) {
+	for alias := range s.aliases[v] {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// does key a come before key b in the folded index
+func foldedBefore(a string, b string) bool {
+	fa, fb := foldKey(a), foldKey(b)
+	if fa != fb {
+		return fa < fb
+	}
+	return a < b
+}
+
+// display form of key k of the entry with value v, empty if k is an
+// alias or its own display form
+func (s *MemoryStore) displayOf(k string, v int64) string {
+	if s.aliases[v][k] {
+		return ""
+	}
+	return s.display[v]
+}
+
+// has the entry with value v expired. Expired entries are hidden from
+// reads and removed by the next write.
+func (s *MemoryStore) expired(v int64) bool {
+	expiry, ok := s.expiry[v]
+	return ok && !now().Before(expiry)
+}
+
+// removes all expired entries. Assumes the write lock is held.
+func (s *MemoryStore) purgeExpired() {
+	for v := range s.expiry {
+		if s.expired(v) {
+			s.deleteEntry(v)
+		}
+	}
+}
+
+// is there an entry with value v in entries
+func containsValue(entries []Entry, v int64) bool {
+	for _, e := range entries {
+		if e.Value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// reads up to n distinct random entries matching filter from store,
+// fewer if fewer match, each equally likely
+func (s *MemoryStore) ReadRandomEntries(n int, filter RandomFilter) (entries []Entry, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	prefix := s.normalizer.NormalizePrefix(filter.Prefix)
+	values := make([]int64, 0, len(s.v2k))
+	for v, k := range s.v2k {
+		if valueInRange(v) && !s.expired(v) && strings.HasPrefix(k, prefix) && metadataMatches(s.metadata[v], filter.Metadata) {
+			values = append(values, v)
+		}
+	}
+	if len(values) < n {
+		n = len(values)
+	}
+	entries = []Entry{}
+	for _, i := range rand.Perm(len(values))[:n] {
+		entries = append(entries, Entry{Key: s.v2k[values[i]], Value: values[i], Display: s.display[values[i]]})
+	}
+	return entries, nil
+}
+
+// retrieves entries from k2v map
+func (s *MemoryStore) GetEntriesFromKeys(keys []string) (entries []Entry, errors []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.normalizer.NormalizeAll(keys) {
+		if err := checkKeys(k); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		if v, ok := s.k2v[k]; ok && !s.expired(v) {
+			entries = append(entries, Entry{Key: k, Value: v, Display: s.displayOf(k, v)})
+		} else {
+			errors = append(errors, fmt.Sprintf("Could not retrieve entry from key %s: %s", k, ErrNotFound.Error()))
+		}
+	}
+	return entries, errors
+}
+
+// retrieves entries from v2k map
+func (s *MemoryStore) GetEntriesFromValues(values []int64) (entries []Entry, errors []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range values {
+		if !valueInRange(v) {
+			errors = append(errors, fmt.Sprintf("Could not retrieve entry from value %d: %s", v, valueOutOfRangeError(v).Error()))
+		} else if k, ok := s.v2k[v]; ok && !s.expired(v) {
+			entries = append(entries, Entry{Key: k, Value: v, Aliases: s.aliasesOf(v), Display: s.display[v]})
+		} else {
+			errors = append(errors, fmt.Sprintf("Could not retrieve entry from value %d: %s", v, ErrNotFound.Error()))
+		}
+	}
+	return entries, errors
+}
+
+// lists up to limit entries created after position after, ordered by
+// creation time and value
+func (s *MemoryStore) ListCreated(after CreatedCursor, limit int) (entries []Entry, errors []string) {
+	entries = []Entry{}
+	errors = []string{}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for v, created := range s.created {
+		if s.expired(v) {
+			continue
+		}
+		created := created
+		e := Entry{Key: s.v2k[v], Value: v, Created: &created, Display: s.display[v]}
+		if after.precedes(e) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return createdCursorOf(entries[i]).precedes(entries[j])
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, errors
+}
+
+// retrieves up to limit entries with keys starting with q after the
+// key of the cursor, in the same byte order as a badger prefix scan
+func (s *MemoryStore) SeekWithPrefix(q string, after SearchCursor, limit int) (entries []Entry, errors []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	q = s.normalizer.NormalizePrefix(q)
+	keys := []string{}
+	for k, v := range s.k2v {
+		if strings.HasPrefix(k, q) && k > after.Key && !s.expired(v) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for i := 0; i < len(keys) && i < limit; i++ {
+		v := s.k2v[keys[i]]
+		entries = append(entries, Entry{Key: keys[i], Value: v, Display: s.displayOf(keys[i], v)})
+	}
+	return entries, errors
+}
+
+// calls f with up to limit entries in r after the key of the cursor,
+// in key order. A limit of 0 scans all of r. Only the keys are copied up
+// front, entries are copied STREAM_FLUSH_SIZE at a time before f is
+// called, so that f doesn't block writers. Keys deleted in the meantime
+// are skipped.
+func (s *MemoryStore) ScanKeys(r KeyRange, after SearchCursor, limit int, f func(Entry) error) error {
+	s.mu.RLock()
+	keys := []string{}
+	for k, v := range s.k2v {
+		if r.contains(k) && k > after.Key && !s.expired(v) {
+			keys = append(keys, k)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	for len(keys) > 0 {
+		chunk := keys
+		if len(chunk) > STREAM_FLUSH_SIZE {
+			chunk = chunk[:STREAM_FLUSH_SIZE]
+		}
+		keys = keys[len(chunk):]
+		entries := make([]Entry, 0, len(chunk))
+		s.mu.RLock()
+		for _, k := range chunk {
+			if v, ok := s.k2v[k]; ok && !s.expired(v) {
+				entries = append(entries, Entry{Key: k, Value: v, Display: s.displayOf(k, v)})
+			}
+		}
+		s.mu.RUnlock()
+		for _, e := range entries {
+			if err := f(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// calls f with up to limit entries with values in r after the value of
+// the cursor, in numeric order. A limit of 0 scans all of r. Entries are
+// copied before f is called.
+func (s *MemoryStore) ScanValues(r ValueRange, after SearchCursor, limit int, f func(Entry) error) error {
+	r = r.after(after)
+	s.mu.RLock()
+	values := []int64{}
+	for v := range s.v2k {
+		if v >= r.Min && v <= r.Max && !s.expired(v) {
+			values = append(values, v)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	if limit > 0 && len(values) > limit {
+		values = values[:limit]
+	}
+	entries := make([]Entry, len(values))
+	for i, v := range values {
+		entries[i] = Entry{Key: s.v2k[v], Value: v, Display: s.display[v]}
+	}
+	s.mu.RUnlock()
+	for _, e := range entries {
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retrieves up to limit entries with keys containing q ignoring case,
+// ranked by containsRank, after the key of the cursor. Scans all
+// keys, so unlike the badger store it needs no index.
+func (s *MemoryStore) SearchContains(q string, after SearchCursor, limit int) (entries []Entry, errors []string) {
+	entries = []Entry{}
+	q = foldKey(s.normalizer.Normalize(q))
+	if q == "" {
+		return entries, errors
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for k, v := range s.k2v {
+		if strings.Contains(foldKey(k), q) && !s.expired(v) {
+			entries = append(entries, Entry{Key: k, Value: v, Display: s.displayOf(k, v)})
+		}
+	}
+	if len(entries) > MAX_SEARCH_CANDIDATES {
+		// keep the same keys as the trigram index would
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+		entries = entries[:MAX_SEARCH_CANDIDATES]
+		errors = append(errors, tooManyCandidatesError(q).Error())
+	}
+	rank := func(e Entry) rankedEntry { return containsRank(e, q) }
+	return rankedPage(rankContains(entries, q), after, rank, limit), errors
+}
+
+// retrieves up to limit entries with keys within the edit distance of
+// q ignoring case, closest first, after the key of the cursor.
+// Compares q with all keys.
+func (s *MemoryStore) SearchFuzzy(q string, distance int, after SearchCursor, limit int) (entries []Entry, errors []string) {
+	q = foldKey(s.normalizer.Normalize(q))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	candidates := []Entry{}
+	for k, v := range s.k2v {
+		if !s.expired(v) {
+			candidates = append(candidates, Entry{Key: k, Value: v, Display: s.displayOf(k, v)})
+		}
+	}
+	rank := func(e Entry) rankedEntry { return fuzzyRank(e, []rune(q)) }
+	return rankedPage(fuzzyMatches(candidates, q, distance), after, rank, limit), errors
+}
+
+// retrieves up to limit entries with keys starting with q ignoring
+// case after the key of the cursor, in the same order as the badger
+// folded index
+func (s *MemoryStore) SeekWithFoldedPrefix(q string, after SearchCursor, limit int) (entries []Entry, errors []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	q = foldKey(s.normalizer.NormalizePrefix(q))
+	keys := []string{}
+	for k, v := range s.k2v {
+		if strings.HasPrefix(foldKey(k), q) && !s.expired(v) && (after.Key == "" || foldedBefore(after.Key, k)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return foldedBefore(keys[i], keys[j])
+	})
+	for i := 0; i < len(keys) && i < limit; i++ {
+		v := s.k2v[keys[i]]
+		entries = append(entries, Entry{Key: keys[i], Value: v, Display: s.displayOf(keys[i], v)})
+	}
+	return entries, errors
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestMemoryCreateIfDoesntExist(t *testing.T) {
+	s := newTestMemoryStore(t)
+
+	type Test struct {
+		Name                  string
+		Keys                  []string
+		MuteAlreadyExists     bool
+		ExpectedEntriesLength int
+		ExpectedErrors        []string
+		Setup                 func()
+	}
+
+	testTable := []Test{
+		Test{
+			Name:                  "adds entries succesfully",
+			Keys:                  []string{"test1", "test2"},
+			MuteAlreadyExists:     false,
+			ExpectedEntriesLength: 2,
+			ExpectedErrors:        []string{},
+			Setup:                 func() {},
+		},
+		Test{
+			Name:                  "(MuteAlreadyExists=true)",
+			Keys:                  []string{"alreadyExists"},
+			MuteAlreadyExists:     true,
+			ExpectedEntriesLength: 1,
+			ExpectedErrors:        []string{},
+			Setup: func() {
+				s.CreateIfDoesntExist([]string{"alreadyExists"}, true)
+			},
+		},
+		Test{
+			Name:                  "(MuteAlreadyExists=false)",
+			Keys:                  []string{"alreadyExists1"},
+			MuteAlreadyExists:     false,
+			ExpectedEntriesLength: 1,
+			ExpectedErrors:        []string{"Key alreadyExists1 already exists in DB"},
+			Setup: func() {
+				s.CreateIfDoesntExist([]string{"alreadyExists1"}, true)
+			},
+		},
+		Test{
+			Name:                  "Mix of already exists and new",
+			Keys:                  []string{"key", "key1", "key2", "alreadyExists2"},
+			MuteAlreadyExists:     true,
+			ExpectedEntriesLength: 4,
+			ExpectedErrors:        []string{},
+			Setup: func() {
+				s.CreateIfDoesntExist([]string{"alreadyExists2"}, true)
+			},
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			test.Setup()
+			entries, errors := s.CreateIfDoesntExist(test.Keys, test.MuteAlreadyExists)
+			assert.Equal(t, test.ExpectedEntriesLength, len(entries))
+			assert.Equal(t, test.ExpectedErrors, errors)
+		})
+	}
+
+	t.Run("keeps both directions in sync", func(t *testing.T) {
+		assert.Equal(t, len(s.k2v), len(s.v2k))
+		for k, v := range s.k2v {
+			assert.Equal(t, k, s.v2k[v])
+		}
+	})
+}
+
+func TestMemoryConcurrentCreateIfDoesntExist(t *testing.T) {
+	_AssertConcurrentCreatesAreUnique(t, newTestMemoryStore(t))
+}
+
+func TestMemoryDeleteEntries(t *testing.T) {
+	_AssertDeleteEntries(t, newTestMemoryStore(t))
+}
+
+func TestMemoryRenameEntries(t *testing.T) {
+	_AssertRenameEntries(t, newTestMemoryStore(t))
+}
+
+func TestMemoryImportEntries(t *testing.T) {
+	_AssertImportEntries(t, newTestMemoryStore(t))
+}
+
+func TestMemoryAliases(t *testing.T) {
+	_AssertAliases(t, newTestMemoryStore(t))
+}
+
+func TestMemoryNamespaces(t *testing.T) {
+	os.Setenv("GRAPH_DB_ID_ALLOCATION", ALLOCATION_SEQUENTIAL)
+	defer os.Unsetenv("GRAPH_DB_ID_ALLOCATION")
+	_AssertNamespaces(t, newTestMemoryStore(t))
+}
+
+func TestMemoryMetadata(t *testing.T) {
+	_AssertMetadata(t, newTestMemoryStore(t))
+}
+
+func TestMemoryListCreated(t *testing.T) {
+	_AssertListCreated(t, newTestMemoryStore(t))
+}
+
+func TestMemoryTTL(t *testing.T) {
+	_AssertTTL(t, newTestMemoryStore(t))
+}
+
+func TestMemoryGenerateEntry(t *testing.T) {
+	s := newTestMemoryStore(t)
+	defer func() { MAX_VALUE = 999999999 }()
+
+	t.Run("generates new Entry succesfully", func(t *testing.T) {
+		e, err := s.GenerateEntry("New Entry")
+		assert.Nil(t, err)
+		assert.Equal(t, "New Entry", e.Key)
+	})
+	t.Run("throws error on many collisions", func(t *testing.T) {
+		MAX_VALUE = 1
+		s.v2k[1] = "collision-before"
+		_, err := s.GenerateEntry("collision")
+		assert.NotNil(t, err)
+		assert.Equal(t, "Too many collisions on creating collision", err.Error())
+	})
+	t.Run("reports collisions on creating entries", func(t *testing.T) {
+		MAX_VALUE = 1
+		entries, errors := s.CreateIfDoesntExist([]string{"collision"}, false)
+		assert.Equal(t, []Entry{}, entries)
+		assert.Equal(t, []string{"Too many collisions on creating collision"}, errors)
+	})
+}
+
+func TestMemoryLookups(t *testing.T) {
+	s := newTestMemoryStore(t)
+	s.k2v["testKEY"] = 111
+	s.v2k[111] = "testKEY"
+	for _, k := range []string{"TESTPREFIX1", "TESTPREFIX2", "TESTPREFIX3"} {
+		s.CreateIfDoesntExist([]string{k}, true)
+	}
+
+	t.Run("retrieves entries from keys", func(t *testing.T) {
+		entries, errors := s.GetEntriesFromKeys([]string{"testKEY", "missing"})
+		assert.Equal(t, []Entry{Entry{Key: "testKEY", Value: 111}}, entries)
+		assert.Equal(t, []string{"Could not retrieve entry from key missing: Key not found"}, errors)
+	})
+	t.Run("retrieves entries from values", func(t *testing.T) {
+		entries, errors := s.GetEntriesFromValues([]int64{111, 112})
+		assert.Equal(t, []Entry{Entry{Key: "testKEY", Value: 111}}, entries)
+		assert.Equal(t, []string{"Could not retrieve entry from value 112: Key not found"}, errors)
+	})
+	t.Run("seeks with prefix in key order", func(t *testing.T) {
+		entries, errors := s.SeekWithPrefix("TESTPREFIX", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(errors))
+		assert.Equal(t, 3, len(entries))
+		assert.Equal(t, "TESTPREFIX1", entries[0].Key)
+		assert.Equal(t, "TESTPREFIX3", entries[2].Key)
+	})
+	t.Run("does not search by case", func(t *testing.T) {
+		entries, _ := s.SeekWithPrefix("tESTPREFIX", SearchCursor{}, MAX_QUERY_RESULTS)
+		assert.Equal(t, 0, len(entries))
+	})
+	t.Run("reads random entries", func(t *testing.T) {
+		entries, err := s.ReadRandomEntries(3, RandomFilter{})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(entries))
+	})
+	t.Run("returns all entries when there are not enough entries", func(t *testing.T) {
+		entries, err := s.ReadRandomEntries(10, RandomFilter{})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(entries))
+	})
+}
+
+// new memory store configured from the environment
+func newTestMemoryStore(t *testing.T) *MemoryStore {
+	s, err := NewMemoryStore()
+	require.Nil(t, err)
+	return s
+}
+
+// points the environment at a fresh store directory with layout, remove
+// deletes it again
+func useTestStoreDir(t *testing.T, layout string) (remove func()) {
+	loadPath := "/tmp/twowaykv/" + strconv.Itoa(rand.Int())
+	require.NoError(t, os.MkdirAll(loadPath, os.ModePerm))
+	os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
+	os.Setenv("GRAPH_DB_STORE_LAYOUT", layout)
+	return func() {
+		os.Unsetenv("GRAPH_DB_STORE_LAYOUT")
+		os.RemoveAll(loadPath)
+	}
+}
+
+// new badger store with layout in a fresh directory, configured from the
+// rest of the environment. remove deletes the directory once the store
+// is closed.
+func newTestBadgerStore(t *testing.T, layout string) (s *BadgerStore, remove func()) {
+	remove = useTestStoreDir(t, layout)
+	s, err := NewBadgerStore()
+	if err != nil {
+		remove()
+	}
+	require.Nil(t, err)
+	return s, remove
+}
+
+// runs fn as a subtest with a new badger store of each layout
+func forEachLayout(t *testing.T, fn func(t *testing.T, s *BadgerStore)) {
+	for _, layout := range []string{LAYOUT_SPLIT, LAYOUT_SINGLE} {
+		t.Run(layout, func(t *testing.T) {
+			s, remove := newTestBadgerStore(t, layout)
+			defer remove()
+			defer s.Close()
+			fn(t, s)
+		})
+	}
+}
+
+func TestNewMemoryStore(t *testing.T) {
+	t.Run("fails on unknown key normalization", func(t *testing.T) {
+		os.Setenv("GRAPH_DB_KEY_NORMALIZATION", "stem")
+		defer os.Unsetenv("GRAPH_DB_KEY_NORMALIZATION")
+		_, err := NewMemoryStore()
+		assert.EqualError(t, err, "Unknown key normalization 'stem'")
+	})
+}
+
+func TestMemoryKeyNormalization(t *testing.T) {
+	os.Setenv("GRAPH_DB_KEY_NORMALIZATION", "nfc,fold,whitespace")
+	os.Setenv("GRAPH_DB_KEEP_DISPLAY_KEY", "true")
+	defer os.Unsetenv("GRAPH_DB_KEY_NORMALIZATION")
+	defer os.Unsetenv("GRAPH_DB_KEEP_DISPLAY_KEY")
+	_AssertKeyNormalization(t, newTestMemoryStore(t))
+}
+
+func TestMemorySeekWithFoldedPrefix(t *testing.T) {
+	_AssertSeekWithFoldedPrefix(t, newTestMemoryStore(t))
+}
+
+func TestMemorySearchContains(t *testing.T) {
+	_AssertSearchContains(t, newTestMemoryStore(t))
+}
+
+func TestMemorySearchFuzzy(t *testing.T) {
+	_AssertSearchFuzzy(t, newTestMemoryStore(t))
+}
+
+func TestMemorySearchPagination(t *testing.T) {
+	_AssertSearchPagination(t, newTestMemoryStore(t))
+}
+
+func TestMemoryScanKeys(t *testing.T) {
+	_AssertScanKeys(t, newTestMemoryStore(t))
+
+	t.Run("copies entries in chunks, skipping keys deleted meanwhile", func(t *testing.T) {
+		STREAM_FLUSH_SIZE = 2
+		defer func() { STREAM_FLUSH_SIZE = 100 }()
+		s := newTestMemoryStore(t)
+		_, errors := s.CreateIfDoesntExist([]string{"a", "b", "c", "d"}, false)
+		require.Equal(t, []string{}, errors)
+		keys := []string{}
+		err := s.ScanKeys(KeyRange{}, SearchCursor{}, 0, func(e Entry) error {
+			keys = append(keys, e.Key)
+			if e.Key == "a" {
+				// writes while scanning don't block
+				_, errors := s.DeleteEntries([]string{"b", "d"}, []int64{})
+				require.Equal(t, []string{}, errors)
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a", "b", "c"}, keys)
+	})
+}
+
+func TestMemoryScanValues(t *testing.T) {
+	_AssertScanValues(t, newTestMemoryStore(t))
+}
+
+func TestMemoryReadRandomEntriesUniform(t *testing.T) {
+	_AssertReadRandomEntriesUniform(t, newTestMemoryStore(t))
+}
+
+func TestMemoryReadRandomEntriesFiltered(t *testing.T) {
+	_AssertReadRandomEntriesFiltered(t, newTestMemoryStore(t))
+}
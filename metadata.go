@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// largest metadata document stored with an entry, in bytes
+var MAX_METADATA_SIZE = 4096
+
+// checks that m can be stored as metadata of key and compacts it.
+// Empty and null metadata is returned as nil, clearing the metadata.
+func checkMetadata(key string, m json.RawMessage) (json.RawMessage, error) {
+	m = bytes.TrimSpace(m)
+	if len(m) == 0 || bytes.Equal(m, []byte("null")) {
+		return nil, nil
+	}
+	if m[0] != '{' {
+		return nil, fmt.Errorf("Metadata of %s must be a JSON object", key)
+	}
+	compact := bytes.Buffer{}
+	if err := json.Compact(&compact, m); err != nil {
+		return nil, fmt.Errorf("Metadata of %s is invalid: %s", key, err.Error())
+	}
+	if compact.Len() > MAX_METADATA_SIZE {
+		return nil, fmt.Errorf("Metadata of %s is larger than %d bytes", key, MAX_METADATA_SIZE)
+	}
+	return compact.Bytes(), nil
+}
+
+// does metadata m have each of the fields of want with the same value.
+// Entries without metadata only match an empty want.
+func metadataMatches(m json.RawMessage, want map[string]interface{}) bool {
+	if len(want) == 0 {
+		return true
+	}
+	fields := map[string]interface{}{}
+	if len(m) == 0 || json.Unmarshal(m, &fields) != nil {
+		return false
+	}
+	for field, v := range want {
+		if found, ok := fields[field]; !ok || !reflect.DeepEqual(found, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestCheckMetadata(t *testing.T) {
+	type Test struct {
+		Name             string
+		Metadata         json.RawMessage
+		ExpectedMetadata json.RawMessage
+		ExpectedError    string
+	}
+	testTable := []Test{
+		Test{
+			Name:             "compacts objects",
+			Metadata:         json.RawMessage(` { "source": "legacy" } `),
+			ExpectedMetadata: json.RawMessage(`{"source":"legacy"}`),
+		},
+		Test{
+			Name:             "clears on null",
+			Metadata:         json.RawMessage(`null`),
+			ExpectedMetadata: nil,
+		},
+		Test{
+			Name:             "clears on missing metadata",
+			Metadata:         nil,
+			ExpectedMetadata: nil,
+		},
+		Test{
+			Name:          "rejects non objects",
+			Metadata:      json.RawMessage(`"source"`),
+			ExpectedError: "Metadata of k must be a JSON object",
+		},
+		Test{
+			Name:          "rejects large documents",
+			Metadata:      json.RawMessage(`{"a": "` + strings.Repeat("a", MAX_METADATA_SIZE) + `"}`),
+			ExpectedError: "Metadata of k is larger than 4096 bytes",
+		},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			m, err := checkMetadata("k", test.Metadata)
+			if test.ExpectedError != "" {
+				assert.EqualError(t, err, test.ExpectedError)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.ExpectedMetadata, m)
+		})
+	}
+}
+
+func TestMetadataMatches(t *testing.T) {
+	type Test struct {
+		Name     string
+		Metadata json.RawMessage
+		Want     map[string]interface{}
+		Expected bool
+	}
+	testTable := []Test{
+		Test{
+			Name:     "matches anything without fields",
+			Metadata: nil,
+			Want:     map[string]interface{}{},
+			Expected: true,
+		},
+		Test{
+			Name:     "matches equal fields",
+			Metadata: json.RawMessage(`{"source":"legacy","rank":2,"tags":["a"]}`),
+			Want:     map[string]interface{}{"rank": float64(2), "tags": []interface{}{"a"}},
+			Expected: true,
+		},
+		Test{
+			Name:     "does not match different fields",
+			Metadata: json.RawMessage(`{"source":"legacy"}`),
+			Want:     map[string]interface{}{"source": "new"},
+			Expected: false,
+		},
+		Test{
+			Name:     "does not match missing fields",
+			Metadata: json.RawMessage(`{"source":"legacy"}`),
+			Want:     map[string]interface{}{"rank": float64(2)},
+			Expected: false,
+		},
+		Test{
+			Name:     "does not match missing metadata",
+			Metadata: nil,
+			Want:     map[string]interface{}{"source": "legacy"},
+			Expected: false,
+		},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, metadataMatches(test.Metadata, test.Want))
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// names of namespaces, used in request paths and store keys
+var NAMESPACE_NAME = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// returned when namespaces are looked up from a namespace's store
+var ErrNestedNamespace = errors.New("Namespaces can't be nested")
+
+// checks that name can be used as a namespace
+func checkNamespaceName(name string) error {
+	if !NAMESPACE_NAME.MatchString(name) {
+		return fmt.Errorf("Namespace name must be 1 to 64 letters, digits, '-' or '_' but was '%s'", name)
+	}
+	return nil
+}
+
+// error for lookups of namespaces which were never created
+func namespaceNotFoundError(name string) error {
+	return fmt.Errorf("Namespace %s not found", name)
+}
+
+// error for creating namespaces which already exist
+func namespaceExistsError(name string) error {
+	return fmt.Errorf("Namespace %s already exists", name)
+}
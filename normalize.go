@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// supported steps of GRAPH_DB_KEY_NORMALIZATION
+const NORMALIZE_FOLD = "fold"
+const NORMALIZE_NFC = "nfc"
+const NORMALIZE_WHITESPACE = "whitespace"
+
+// rewrites keys before they are stored or looked up, so that keys only
+// differing in case, unicode composition or whitespace map to the same
+// entry. A nil KeyNormalizer leaves keys unchanged.
+type KeyNormalizer struct {
+	// applied in order
+	steps []string
+	// keep the original form of new keys as their display form
+	keepDisplay bool
+}
+
+// creates a normalizer applying the comma separated steps in order
+func NewKeyNormalizer(steps string, keepDisplay bool) (*KeyNormalizer, error) {
+	n := &KeyNormalizer{keepDisplay: keepDisplay}
+	for _, step := range strings.Split(steps, ",") {
+		step = strings.TrimSpace(step)
+		switch step {
+		case "":
+		case NORMALIZE_FOLD, NORMALIZE_NFC, NORMALIZE_WHITESPACE:
+			n.steps = append(n.steps, step)
+		default:
+			return nil, fmt.Errorf("Unknown key normalization '%s'", step)
+		}
+	}
+	return n, nil
+}
+
+// normalizer configured by GRAPH_DB_KEY_NORMALIZATION and
+// GRAPH_DB_KEEP_DISPLAY_KEY
+func KeyNormalizerFromEnv() (*KeyNormalizer, error) {
+	return NewKeyNormalizer(
+		os.Getenv("GRAPH_DB_KEY_NORMALIZATION"),
+		os.Getenv("GRAPH_DB_KEEP_DISPLAY_KEY") == "true",
+	)
+}
+
+// error for keys a and b which both normalize to key k
+func normalizationConflictError(a string, b string, k string) error {
+	return fmt.Errorf("Keys %s and %s both normalize to %s, rename one of them before setting GRAPH_DB_KEY_NORMALIZATION", a, b, k)
+}
+
+// fails for keys which aren't valid UTF-8. These include all keys
+// starting with META_PREFIX, which could otherwise address bookkeeping
+// keys or keys of namespaces.
+func checkKeys(keys ...string) error {
+	for _, k := range keys {
+		if !utf8.ValidString(k) {
+			return fmt.Errorf("Key %q is not valid UTF-8", k)
+		}
+	}
+	return nil
+}
+
+// comma separated steps of n, empty if n leaves keys unchanged
+func (n *KeyNormalizer) String() string {
+	if n == nil {
+		return ""
+	}
+	return strings.Join(n.steps, ",")
+}
+
+// normalized form of key k
+func (n *KeyNormalizer) Normalize(k string) string {
+	if n == nil {
+		return k
+	}
+	for _, step := range n.steps {
+		switch step {
+		case NORMALIZE_FOLD:
+			k = cases.Fold().String(k)
+		case NORMALIZE_NFC:
+			k = norm.NFC.String(k)
+		case NORMALIZE_WHITESPACE:
+			k = strings.Join(strings.Fields(k), " ")
+		}
+	}
+	return k
+}
+
+// normalized form of a prefix of keys. Unlike whole keys, trailing
+// whitespace is kept as a single space since it separates the prefix
+// from the rest of the key.
+func (n *KeyNormalizer) NormalizePrefix(q string) string {
+	normalized := n.Normalize(q)
+	if normalized != "" && n.has(NORMALIZE_WHITESPACE) {
+		if r := []rune(q); unicode.IsSpace(r[len(r)-1]) {
+			normalized += " "
+		}
+	}
+	return normalized
+}
+
+// display form to store for a key created as original, empty if the
+// display form isn't kept or is the same as the normalized key
+func (n *KeyNormalizer) Display(original string, normalized string) string {
+	if n == nil || !n.keepDisplay || original == normalized {
+		return ""
+	}
+	return original
+}
+
+// distinct normalized forms of keys, in the order of keys
+func (n *KeyNormalizer) NormalizeAll(keys []string) []string {
+	if n == nil {
+		return keys
+	}
+	normalized := make([]string, len(keys))
+	for i, k := range keys {
+		normalized[i] = n.Normalize(k)
+	}
+	return removeDuplicates(normalized)
+}
+
+// is step one of the steps of n
+func (n *KeyNormalizer) has(step string) bool {
+	if n == nil {
+		return false
+	}
+	for _, s := range n.steps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
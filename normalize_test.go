@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestKeyNormalizer(t *testing.T) {
+	type Test struct {
+		Name           string
+		Steps          string
+		Key            string
+		ExpectedKey    string
+		ExpectedPrefix string
+	}
+
+	testTable := []Test{
+		Test{
+			Name:           "leaves keys unchanged without steps",
+			Steps:          "",
+			Key:            " Barack  Obama ",
+			ExpectedKey:    " Barack  Obama ",
+			ExpectedPrefix: " Barack  Obama ",
+		},
+		Test{
+			Name:           "folds case",
+			Steps:          "fold",
+			Key:            "Straße",
+			ExpectedKey:    "strasse",
+			ExpectedPrefix: "strasse",
+		},
+		Test{
+			Name:           "composes unicode",
+			Steps:          "nfc",
+			Key:            "Café",
+			ExpectedKey:    "Café",
+			ExpectedPrefix: "Café",
+		},
+		Test{
+			Name:           "trims and collapses whitespace",
+			Steps:          "whitespace",
+			Key:            " Barack \t Obama ",
+			ExpectedKey:    "Barack Obama",
+			ExpectedPrefix: "Barack Obama ",
+		},
+		Test{
+			Name:           "applies all steps",
+			Steps:          "nfc, fold,whitespace",
+			Key:            "  CAFÉ Society",
+			ExpectedKey:    "café society",
+			ExpectedPrefix: "café society",
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			n, err := NewKeyNormalizer(test.Steps, false)
+			require.Nil(t, err)
+			assert.Equal(t, test.ExpectedKey, n.Normalize(test.Key))
+			assert.Equal(t, test.ExpectedPrefix, n.NormalizePrefix(test.Key))
+		})
+	}
+
+	t.Run("rejects unknown steps", func(t *testing.T) {
+		_, err := NewKeyNormalizer("fold,stem", false)
+		assert.EqualError(t, err, "Unknown key normalization 'stem'")
+	})
+
+	t.Run("only keeps display forms if configured", func(t *testing.T) {
+		n, _ := NewKeyNormalizer("fold", false)
+		assert.Equal(t, "", n.Display("Obama", "obama"))
+		n, _ = NewKeyNormalizer("fold", true)
+		assert.Equal(t, "Obama", n.Display("Obama", "obama"))
+		assert.Equal(t, "", n.Display("obama", "obama"))
+	})
+
+	t.Run("nil normalizer leaves keys unchanged", func(t *testing.T) {
+		var n *KeyNormalizer
+		assert.Equal(t, "Obama ", n.Normalize("Obama "))
+		assert.Equal(t, []string{"a", "a"}, n.NormalizeAll([]string{"a", "a"}))
+	})
+
+	t.Run("reads configuration from env", func(t *testing.T) {
+		os.Setenv("GRAPH_DB_KEY_NORMALIZATION", "fold")
+		os.Setenv("GRAPH_DB_KEEP_DISPLAY_KEY", "true")
+		defer os.Unsetenv("GRAPH_DB_KEY_NORMALIZATION")
+		defer os.Unsetenv("GRAPH_DB_KEEP_DISPLAY_KEY")
+		n, err := KeyNormalizerFromEnv()
+		require.Nil(t, err)
+		assert.Equal(t, []string{"obama"}, n.NormalizeAll([]string{"Obama", "OBAMA"}))
+		assert.Equal(t, "Obama", n.Display("Obama", "obama"))
+	})
+}
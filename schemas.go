@@ -1,19 +1,79 @@
 package main
 
 import (
-	badger "github.com/dgraph-io/badger"
+	"encoding/json"
+	"time"
 )
 
 // server environment
 type Server struct {
-	K2v                 *badger.DB
-	V2k                 *badger.DB
-	CreateIfDoesntExist func([]string, bool, *badger.DB, *badger.DB) ([]Entry, []string)
+	Store Store
+}
+
+// two-way key => value and value => key storage backend
+type Store interface {
+	// adds new entries for keys which don't already exist
+	CreateIfDoesntExist(keys []string, muteAlreadyExists bool) ([]Entry, []string)
+	// adds new entries for keys which don't already exist, expiring after ttl
+	CreateWithTTL(keys []string, muteAlreadyExists bool, ttl time.Duration) ([]Entry, []string)
+	// looks up entries by key
+	GetEntriesFromKeys(keys []string) ([]Entry, []string)
+	// looks up entries by value
+	GetEntriesFromValues(values []int64) ([]Entry, []string)
+	// removes entries by key or by value
+	DeleteEntries(keys []string, values []int64) ([]Entry, []string)
+	// adds entries with values chosen by the client
+	ImportEntries(entries []Entry) ([]Entry, []string)
+	// adds extra keys resolving to the values of existing keys
+	AddAliases(aliases []Alias) ([]Entry, []string)
+	// removes extra keys, leaving their entries in place
+	RemoveAliases(aliases []string) ([]Entry, []string)
+	// replaces the metadata of existing keys, leaving their values unchanged
+	UpdateMetadata(updates []MetadataUpdate) ([]Entry, []string)
+	// fills in the metadata of entries
+	WithMetadata(entries []Entry) ([]Entry, []string)
+	// moves values to new keys
+	RenameEntries(renames []Rename) ([]Entry, []string)
+	// lists entries created after a position, oldest first
+	ListCreated(after CreatedCursor, limit int) ([]Entry, []string)
+	// finds entries with keys starting with a prefix
+	SeekWithPrefix(q string, after SearchCursor, limit int) ([]Entry, []string)
+	// same as SeekWithPrefix, ignoring case
+	SeekWithFoldedPrefix(q string, after SearchCursor, limit int) ([]Entry, []string)
+	// finds entries with keys containing a substring, ignoring case
+	SearchContains(q string, after SearchCursor, limit int) ([]Entry, []string)
+	// finds entries with keys within an edit distance, ignoring case
+	SearchFuzzy(q string, distance int, after SearchCursor, limit int) ([]Entry, []string)
+	// calls f with up to limit entries in r after the key of the cursor,
+	// in key order. A limit of 0 scans all of r.
+	ScanKeys(r KeyRange, after SearchCursor, limit int, f func(Entry) error) error
+	// calls f with up to limit entries with values in r after the value
+	// of the cursor, in numeric order. A limit of 0 scans all of r.
+	ScanValues(r ValueRange, after SearchCursor, limit int, f func(Entry) error) error
+	// samples a number of random entries matching filter
+	ReadRandomEntries(n int, filter RandomFilter) ([]Entry, error)
+	// store of an existing namespace, sharing the resources of this store
+	Namespace(name string) (Store, error)
+	// adds a new, empty namespace
+	CreateNamespace(name string) error
+	// sorted names of all namespaces
+	ListNamespaces() ([]string, error)
+	// releases underlying resources
+	Close() error
 }
 
 type Entry struct {
 	Key   string `json:"key" binding:"required"`
-	Value int    `json:"value" binding:"required"`
+	Value int64  `json:"value" binding:"required"`
+	// original form of Key before normalization, only set if kept and
+	// different from Key
+	Display string `json:"display,omitempty"`
+	// other keys resolving to Value, only set on lookups by value
+	Aliases []string `json:"aliases,omitempty"`
+	// JSON object stored with the entry, only set when requested
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	// when the entry was created, only set when listing by creation time
+	Created *time.Time `json:"created,omitempty"`
 }
 
 type RetrieveEntryResponse struct {
@@ -21,6 +81,96 @@ type RetrieveEntryResponse struct {
 	Entries []Entry  `json:"entries" binding:"required"`
 }
 
+// body of DELETE /entries, entries are looked up by key and by value
+type DeleteEntriesRequest struct {
+	Keys   []string `json:"keys"`
+	Values []int64  `json:"values"`
+}
+
+// moves the value of key From to key To
+type Rename struct {
+	From string `json:"from" binding:"required"`
+	To   string `json:"to" binding:"required"`
+}
+
+// new metadata of the entry of Key, null clears it
+type MetadataUpdate struct {
+	Key      string          `json:"key" binding:"required"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+// makes Alias resolve to the value of Key
+type Alias struct {
+	Alias string `json:"alias" binding:"required"`
+	Key   string `json:"key" binding:"required"`
+}
+
+// page of entries ordered by creation time. Cursor is the position of
+// the last entry, or of the request if no entries were returned.
+type CreatedEntriesResponse struct {
+	Errors  []string `json:"errors"`
+	Entries []Entry  `json:"entries"`
+	Cursor  string   `json:"cursor"`
+}
+
+// range of keys, Start is inclusive and End is exclusive. Empty bounds
+// leave the range open.
+type KeyRange struct {
+	Start string
+	End   string
+}
+
+// is key k in r
+func (r KeyRange) contains(k string) bool {
+	return k >= r.Start && (r.End == "" || k < r.End)
+}
+
+// range of values, both bounds are inclusive
+type ValueRange struct {
+	Min int64
+	Max int64
+}
+
+// part of r after the value of the cursor, empty if the cursor is at or
+// after the end of r
+func (r ValueRange) after(c SearchCursor) ValueRange {
+	if c.Key == "" || c.Value < r.Min {
+		return r
+	}
+	if c.Value >= r.Max {
+		return ValueRange{1, 0}
+	}
+	return ValueRange{c.Value + 1, r.Max}
+}
+
+// subset of entries sampled by ReadRandomEntries. The zero filter
+// matches all entries.
+type RandomFilter struct {
+	// keys start with Prefix
+	Prefix string
+	// metadata has each of the fields of Metadata with the same value
+	Metadata map[string]interface{}
+}
+
+// does f match all entries
+func (f RandomFilter) matchesAll() bool {
+	return f.Prefix == "" && len(f.Metadata) == 0
+}
+
+// page of search results. Cursor continues after the last entry, and
+// is only set if the page is full.
+type SearchResponse struct {
+	Errors  []string `json:"errors"`
+	Entries []Entry  `json:"entries"`
+	Cursor  string   `json:"cursor,omitempty"`
+}
+
+// response of the namespace admin endpoints
+type NamespacesResponse struct {
+	Errors     []string `json:"errors"`
+	Namespaces []string `json:"namespaces"`
+}
+
 type Error struct {
 	Code  int
 	Error string
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/text/cases"
+	"strings"
+)
+
+// supported values of "mode" on /search
+const SEARCH_MODE_PREFIX = "prefix"
+const SEARCH_MODE_INSENSITIVE = "insensitive"
+const SEARCH_MODE_CONTAINS = "contains"
+const SEARCH_MODE_FUZZY = "fuzzy"
+
+// largest edit distance of fuzzy search
+var MAX_FUZZY_DISTANCE = 2
+
+// number of search results returned unless a limit is given, and the
+// largest limit, set by GRAPH_DB_MAX_SEARCH_LIMIT
+var MAX_QUERY_RESULTS = 25
+var MAX_SEARCH_LIMIT = 1000
+
+// returned by substring search on badger stores without trigram index
+var ErrNoTrigramIndex = errors.New("Substring and fuzzy search need GRAPH_DB_TRIGRAM_INDEX=true")
+
+// most keys matching a substring query which are ranked, and most
+// candidates of a fuzzy query which are compared with it
+var MAX_SEARCH_CANDIDATES = 10000
+
+// reported when more than MAX_SEARCH_CANDIDATES keys match q
+func tooManyCandidatesError(q string) error {
+	return fmt.Errorf("Search for '%s' matched more than %d keys, only %d of them were ranked", q, MAX_SEARCH_CANDIDATES, MAX_SEARCH_CANDIDATES)
+}
+
+// reported when fuzzy search for q found more than
+// MAX_SEARCH_CANDIDATES candidates in the trigram index
+func tooManyFuzzyCandidatesError(q string) error {
+	return fmt.Errorf("Fuzzy search for '%s' found more than %d candidates, only %d of them were compared", q, MAX_SEARCH_CANDIDATES, MAX_SEARCH_CANDIDATES)
+}
+
+// case folded form of key k, under which it is kept in the folded index
+func foldKey(k string) string {
+	return cases.Fold().String(k)
+}
+
+// distinct grams of the folded key k under which it is kept in the
+// trigram index. These are the up to three runes starting at each
+// position, so every substring of k starts one of them.
+func trigramsOf(k string) (grams []string) {
+	runes := []rune(foldKey(k))
+	seen := make(map[string]bool)
+	for i := range runes {
+		end := i + 3
+		if end > len(runes) {
+			end = len(runes)
+		}
+		gram := string(runes[i:end])
+		if !seen[gram] {
+			grams = append(grams, gram)
+			seen[gram] = true
+		}
+	}
+	return grams
+}
+
+// distinct grams of three runes of the folded query q. Every key
+// containing q is indexed under all of them. Empty for queries shorter
+// than three runes.
+func queryTrigrams(q string) (grams []string) {
+	runes := []rune(q)
+	seen := make(map[string]bool)
+	for i := 0; i+3 <= len(runes); i++ {
+		gram := string(runes[i : i+3])
+		if !seen[gram] {
+			grams = append(grams, gram)
+			seen[gram] = true
+		}
+	}
+	return grams
+}
+
+// entry at its position in the ranking of a search: ordered by score,
+// then tie, then key. Both only depend on the key and the query, so the
+// position of the key of a cursor is known even after it was deleted.
+type rankedEntry struct {
+	Entry
+	score int
+	tie   int
+}
+
+// does a come before b in their ranking
+func (a rankedEntry) before(b rankedEntry) bool {
+	if a.score != b.score {
+		return a.score < b.score
+	}
+	if a.tie != b.tie {
+		return a.tie < b.tie
+	}
+	return a.Key < b.Key
+}
+
+// e ranked by relevance to the folded query q it contains: earlier
+// matches first, so exact matches and prefixes come first, then shorter
+// keys
+func containsRank(e Entry, q string) rankedEntry {
+	return rankedEntry{e, strings.Index(foldKey(e.Key), q), len(e.Key)}
+}
+
+// entries with keys containing the folded query q ranked by
+// containsRank
+func rankContains(entries []Entry, q string) []rankedEntry {
+	ranked := make([]rankedEntry, len(entries))
+	for i, e := range entries {
+		ranked[i] = containsRank(e, q)
+	}
+	return ranked
+}
+
+// fewest of the distinct queryTrigrams grams of a query a key within
+// distance of it must share with it. An edit changes at most three
+// grams. Below 1 the grams can't narrow down candidates.
+func minSharedTrigrams(grams []string, distance int) int {
+	return len(grams) - 3*distance
+}
+
+// q split into distance+1 pieces of about the same length. Every key
+// within the edit distance of q contains one of them, since an edit
+// changes at most one piece. Nil if q has fewer runes than pieces.
+func pigeonholePieces(q string, distance int) (pieces []string) {
+	runes := []rune(q)
+	n := distance + 1
+	if len(runes) < n {
+		return nil
+	}
+	for i := 0; i < n; i++ {
+		pieces = append(pieces, string(runes[i*len(runes)/n:(i+1)*len(runes)/n]))
+	}
+	return pieces
+}
+
+// e ranked by the edit distance of its key from the folded query q
+func fuzzyRank(e Entry, q []rune) rankedEntry {
+	return rankedEntry{e, levenshtein([]rune(foldKey(e.Key)), q), 0}
+}
+
+// candidates with keys within the edit distance of the folded query q,
+// ranked by fuzzyRank
+func fuzzyMatches(candidates []Entry, q string, distance int) []rankedEntry {
+	query := []rune(q)
+	ranked := []rankedEntry{}
+	for _, e := range candidates {
+		key := []rune(foldKey(e.Key))
+		if len(key) > len(query)+distance || len(key) < len(query)-distance {
+			continue
+		}
+		if d := levenshtein(key, query); d <= distance {
+			ranked = append(ranked, rankedEntry{e, d, 0})
+		}
+	}
+	return ranked
+}
+
+// number of rune insertions, deletions and substitutions turning a
+// into b
+func levenshtein(a []rune, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// position after which a search continues. Prefix and ranked searches
+// continue after the last key returned, ranked searches at the position
+// the key has in their ranking, and scans by value after the last value
+// returned.
+type SearchCursor struct {
+	Key   string
+	Value int64
+}
+
+// cursor continuing after entries
+func (c SearchCursor) next(entries []Entry) SearchCursor {
+	if len(entries) == 0 {
+		return c
+	}
+	last := entries[len(entries)-1]
+	return SearchCursor{last.Key, last.Value}
+}
+
+// opaque form of c handed to clients
+func (c SearchCursor) String() string {
+	b := make([]byte, 8, 8+len(c.Key))
+	binary.BigEndian.PutUint64(b, uint64(c.Value))
+	return base64.RawURLEncoding.EncodeToString(append(b, c.Key...))
+}
+
+// parses a cursor returned by SearchCursor.String
+func parseSearchCursor(s string) (SearchCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) < 8 {
+		return SearchCursor{}, fmt.Errorf("Invalid cursor '%s'", s)
+	}
+	return SearchCursor{string(b[8:]), int64(binary.BigEndian.Uint64(b))}, nil
+}
+
+// page of up to limit of the ranked entries, in order, which come after
+// the key of the cursor ranked by rank. Only keeps limit entries while
+// selecting them rather than sorting all of ranked.
+func rankedPage(ranked []rankedEntry, after SearchCursor, rank func(Entry) rankedEntry, limit int) []Entry {
+	page := &rankedHeap{}
+	var cursor rankedEntry
+	if after.Key != "" {
+		cursor = rank(Entry{Key: after.Key, Value: after.Value})
+	}
+	for _, r := range ranked {
+		if after.Key != "" && !cursor.before(r) {
+			continue
+		}
+		if page.Len() < limit {
+			heap.Push(page, r)
+		} else if page.Len() > 0 && r.before((*page)[0]) {
+			(*page)[0] = r
+			heap.Fix(page, 0)
+		}
+	}
+	entries := make([]Entry, page.Len())
+	for i := len(entries) - 1; i >= 0; i-- {
+		entries[i] = heap.Pop(page).(rankedEntry).Entry
+	}
+	return entries
+}
+
+// ranked entries with the last ranked on top
+type rankedHeap []rankedEntry
+
+func (h rankedHeap) Len() int            { return len(h) }
+func (h rankedHeap) Less(i, j int) bool  { return h[j].before(h[i]) }
+func (h rankedHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *rankedHeap) Push(x interface{}) { *h = append(*h, x.(rankedEntry)) }
+func (h *rankedHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTrigramsOf(t *testing.T) {
+	type Test struct {
+		Name          string
+		Key           string
+		ExpectedGrams []string
+	}
+
+	testTable := []Test{
+		Test{
+			Name:          "folds case and includes the short grams at the end",
+			Key:           "ObaMa",
+			ExpectedGrams: []string{"oba", "bam", "ama", "ma", "a"},
+		},
+		Test{
+			Name:          "skips duplicate grams",
+			Key:           "aaaa",
+			ExpectedGrams: []string{"aaa", "aa", "a"},
+		},
+		Test{
+			Name:          "counts runes rather than bytes",
+			Key:           "Café",
+			ExpectedGrams: []string{"caf", "afé", "fé", "é"},
+		},
+		Test{
+			Name:          "has no grams for empty keys",
+			Key:           "",
+			ExpectedGrams: nil,
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.ExpectedGrams, trigramsOf(test.Key))
+		})
+	}
+}
+
+func TestRankContains(t *testing.T) {
+	entries := []Entry{
+		Entry{Key: "Barack Obama"},
+		Entry{Key: "Obama Foundation"},
+		Entry{Key: "Michelle Obama"},
+		Entry{Key: "obama"},
+		Entry{Key: "Obamacare"},
+	}
+	rank := func(e Entry) rankedEntry { return containsRank(e, "obama") }
+	// keys of the page of up to limit entries after the key after
+	page := func(after string, limit int) (keys []string) {
+		for _, e := range rankedPage(rankContains(entries, "obama"), SearchCursor{Key: after}, rank, limit) {
+			keys = append(keys, e.Key)
+		}
+		return keys
+	}
+	assert.Equal(t, []string{"obama", "Obamacare", "Obama Foundation", "Barack Obama", "Michelle Obama"}, page("", 10))
+	assert.Equal(t, []string{"obama", "Obamacare"}, page("", 2))
+	assert.Equal(t, []string{"Obama Foundation", "Barack Obama"}, page("Obamacare", 2))
+	// keys which aren't ranked continue at their own rank
+	assert.Equal(t, []string{"Barack Obama", "Michelle Obama"}, page("Alice Obama", 2))
+	assert.Equal(t, []string(nil), page("Michelle Obama", 2))
+}
+
+func TestPigeonholePieces(t *testing.T) {
+	assert.Equal(t, []string{"o", "ba", "ma"}, pigeonholePieces("obama", 2))
+	assert.Equal(t, []string{"ob", "ama"}, pigeonholePieces("obama", 1))
+	assert.Equal(t, []string{"obama"}, pigeonholePieces("obama", 0))
+	assert.Equal(t, []string{"c", "a", "f", "é"}, pigeonholePieces("café", 3))
+	assert.Equal(t, []string(nil), pigeonholePieces("ob", 2))
+}
+
+func TestLevenshtein(t *testing.T) {
+	type Test struct {
+		A                string
+		B                string
+		ExpectedDistance int
+	}
+
+	testTable := []Test{
+		Test{A: "obama", B: "obama", ExpectedDistance: 0},
+		Test{A: "obama", B: "obamma", ExpectedDistance: 1},
+		Test{A: "obama", B: "oboma", ExpectedDistance: 1},
+		Test{A: "obama", B: "bama", ExpectedDistance: 1},
+		Test{A: "kitten", B: "sitting", ExpectedDistance: 3},
+		Test{A: "", B: "abc", ExpectedDistance: 3},
+		Test{A: "café", B: "cafe", ExpectedDistance: 1},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.A+" "+test.B, func(t *testing.T) {
+			assert.Equal(t, test.ExpectedDistance, levenshtein([]rune(test.A), []rune(test.B)))
+			assert.Equal(t, test.ExpectedDistance, levenshtein([]rune(test.B), []rune(test.A)))
+		})
+	}
+}
+
+func TestSearchCursor(t *testing.T) {
+	t.Run("round trips through its string form", func(t *testing.T) {
+		c := SearchCursor{"Barack Obama", 123}
+		parsed, err := parseSearchCursor(c.String())
+		assert.Nil(t, err)
+		assert.Equal(t, c, parsed)
+	})
+
+	t.Run("rejects invalid cursors", func(t *testing.T) {
+		_, err := parseSearchCursor("abc")
+		assert.EqualError(t, err, "Invalid cursor 'abc'")
+	})
+
+	t.Run("continues after the last entry", func(t *testing.T) {
+		c := SearchCursor{"a", 1}.next([]Entry{Entry{Key: "b", Value: 5}, Entry{Key: "c", Value: 3}})
+		assert.Equal(t, SearchCursor{"c", 3}, c)
+		assert.Equal(t, c, c.next([]Entry{}))
+	})
+}
@@ -1,23 +1,50 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/zsais/go-gin-prometheus"
 	"net/http"
+	"os"
 	"strconv"
+	"time"
 )
 
+// supported values of GRAPH_DB_STORE_TYPE
+const STORE_TYPE_BADGER = "badger"
+const STORE_TYPE_MEMORY = "memory"
+
+// connects to the store selected by GRAPH_DB_STORE_TYPE, defaults to badger
+func ConnectToStore() (Store, error) {
+	switch os.Getenv("GRAPH_DB_STORE_TYPE") {
+	case "", STORE_TYPE_BADGER:
+		store, err := NewBadgerStore()
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case STORE_TYPE_MEMORY:
+		store, err := NewMemoryStore()
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("Unknown store type '%s'", os.Getenv("GRAPH_DB_STORE_TYPE"))
+}
+
 // entrypoint
 func SetupRouter(docs string) (*gin.Engine, *Server) {
 	// try to connect to db
 	logMsg("Connecting to DB")
-	kDB, vDB, err := ConnectToDb()
+	store, err := ConnectToStore()
 	logMsg("Done.")
 	if err != nil {
 		logFatalf("Could not establish connection to db: %v", err)
 	}
 	// create server object
-	s := Server{kDB, vDB, CreateIfDoesntExist}
+	s := Server{store}
 	// define endpoints
 	router := gin.Default()
 	router.Use(gin.Logger())
@@ -33,16 +60,82 @@ func SetupRouter(docs string) (*gin.Engine, *Server) {
 	// metrics
 	p := ginprometheus.NewPrometheus("gin")
 	p.Use(router)
-	// core endpoints
-	router.POST("/entries", s.CreateEntries)
-	router.POST("/entriesFromKeys", s.GetEntriesFromKeys)
-	router.POST("/entriesFromValues", s.GetEntriesFromValues)
-	router.GET("/random", s.RandomEntries)
-	router.GET("/search", s.Search)
+	// core endpoints, on the default store and on each namespace
+	s.addEntryRoutes(router)
+	s.addEntryRoutes(router.Group("/ns/:namespace", s.ResolveNamespace))
+	// namespace admin
+	router.GET("/namespaces", s.ListNamespaces)
+	router.POST("/namespaces", s.CreateNamespaces)
 	// return server
 	return router, &s
 }
 
+// registers the endpoints reading and writing entries
+func (s *Server) addEntryRoutes(r gin.IRoutes) {
+	r.POST("/entries", s.CreateEntries)
+	r.GET("/entries", s.ScanEntries)
+	r.DELETE("/entries", s.DeleteEntries)
+	r.POST("/entries/delete", s.DeleteEntries)
+	r.POST("/entries/import", s.ImportEntries)
+	r.POST("/entries/rename", s.RenameEntries)
+	r.POST("/entries/metadata", s.UpdateMetadata)
+	r.GET("/entries/created", s.CreatedEntries)
+	r.POST("/aliases", s.AddAliases)
+	r.DELETE("/aliases", s.RemoveAliases)
+	r.POST("/aliases/delete", s.RemoveAliases)
+	r.POST("/entriesFromKeys", s.GetEntriesFromKeys)
+	r.POST("/entriesFromValues", s.GetEntriesFromValues)
+	r.GET("/random", s.RandomEntries)
+	r.GET("/search", s.Search)
+}
+
+// store requests are served from, set by ResolveNamespace for
+// namespaced requests
+func (s *Server) store(c *gin.Context) Store {
+	if ns, ok := c.Get("store"); ok {
+		return ns.(Store)
+	}
+	return s.Store
+}
+
+// looks up the namespace in the request path
+func (s *Server) ResolveNamespace(c *gin.Context) {
+	ns, err := s.Store.Namespace(c.Param("namespace"))
+	if err != nil {
+		c.AbortWithStatusJSON(404, Error{404, err.Error()})
+		return
+	}
+	c.Set("store", ns)
+}
+
+// lists the names of all namespaces
+func (s *Server) ListNamespaces(c *gin.Context) {
+	names, err := s.Store.ListNamespaces()
+	if err != nil {
+		c.JSON(500, Error{500, err.Error()})
+		return
+	}
+	c.JSON(200, NamespacesResponse{[]string{}, names})
+}
+
+// creates namespaces given as a list of names in the body
+func (s *Server) CreateNamespaces(c *gin.Context) {
+	names := []string{}
+	if err := c.BindJSON(&names); err != nil {
+		c.JSON(400, Error{400, err.Error()})
+		return
+	}
+	resp := NamespacesResponse{[]string{}, []string{}}
+	for _, name := range removeDuplicates(names) {
+		if err := s.Store.CreateNamespace(name); err != nil {
+			resp.Errors = append(resp.Errors, err.Error())
+			continue
+		}
+		resp.Namespaces = append(resp.Namespaces, name)
+	}
+	c.JSON(200, resp)
+}
+
 // removes duplicate keys in array
 func removeDuplicates(keys []string) (noDuplicates []string) {
 	noDuplicates = []string{}
@@ -56,7 +149,8 @@ func removeDuplicates(keys []string) (noDuplicates []string) {
 	return noDuplicates
 }
 
-// create entries if they don't already exist
+// create entries if they don't already exist, expiring after "ttl" if
+// given
 func (s *Server) CreateEntries(c *gin.Context) {
 	// read in request
 	keysToCreate := []string{}
@@ -64,20 +158,144 @@ func (s *Server) CreateEntries(c *gin.Context) {
 		c.JSON(400, Error{400, err.Error()})
 		return
 	}
+	// optional expiry of new entries
+	ttl := time.Duration(0)
+	if ttlParam := c.Query("ttl"); ttlParam != "" {
+		var err error
+		if ttl, err = time.ParseDuration(ttlParam); err != nil || ttl < time.Second {
+			c.JSON(400, Error{400, "'ttl' must be a duration of at least 1s but was '" + ttlParam + "'"})
+			return
+		}
+	}
 	// create dbs
-	entries, errors := CreateIfDoesntExist(
+	entries, errors := s.store(c).CreateWithTTL(
 		removeDuplicates(keysToCreate),              // remove duplicates from keys passed
 		c.Query("muteAlreadyExistsError") == "true", // log or dont log already exists errors
-		s.K2v,
-		s.V2k,
+		ttl, // expire new entries after ttl
 	)
 	// finally return everything!!
 	c.JSON(200, RetrieveEntryResponse{errors, entries})
 }
 
-// Get a specified number of random entries
+// create entries with values given by the client, e.g. when importing
+// id assignments from another system
+func (s *Server) ImportEntries(c *gin.Context) {
+	toImport := []Entry{}
+	if err := c.BindJSON(&toImport); err != nil {
+		c.JSON(400, Error{400, err.Error()})
+		return
+	}
+	entries, errors := s.store(c).ImportEntries(toImport)
+	c.JSON(200, RetrieveEntryResponse{errors, entries})
+}
+
+// delete entries by key or by value
+func (s *Server) DeleteEntries(c *gin.Context) {
+	req := DeleteEntriesRequest{}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, Error{400, err.Error()})
+		return
+	}
+	entries, errors := s.store(c).DeleteEntries(removeDuplicates(req.Keys), req.Values)
+	c.JSON(200, RetrieveEntryResponse{errors, entries})
+}
+
+// rename a single key given by "from" and "to" query params, or many
+// keys given as a list of renames in the body
+func (s *Server) RenameEntries(c *gin.Context) {
+	renames := []Rename{}
+	if from, to := c.Query("from"), c.Query("to"); from != "" || to != "" {
+		if from == "" || to == "" {
+			c.JSON(400, Error{400, "both 'from' and 'to' must be passed to rename a key"})
+			return
+		}
+		renames = append(renames, Rename{from, to})
+	} else if err := c.BindJSON(&renames); err != nil {
+		c.JSON(400, Error{400, err.Error()})
+		return
+	}
+	entries, errors := s.store(c).RenameEntries(renames)
+	c.JSON(200, RetrieveEntryResponse{errors, entries})
+}
+
+// replace the metadata of entries given by key
+func (s *Server) UpdateMetadata(c *gin.Context) {
+	updates := []MetadataUpdate{}
+	if err := c.BindJSON(&updates); err != nil {
+		c.JSON(400, Error{400, err.Error()})
+		return
+	}
+	entries, errors := s.store(c).UpdateMetadata(updates)
+	c.JSON(200, RetrieveEntryResponse{errors, entries})
+}
+
+// adds the metadata of entries if requested with "metadata=true"
+func (s *Server) withMetadata(c *gin.Context, entries []Entry, errs []string) ([]Entry, []string) {
+	if c.Query("metadata") != "true" {
+		return entries, errs
+	}
+	entries, metadataErrs := s.store(c).WithMetadata(entries)
+	return entries, append(errs, metadataErrs...)
+}
+
+// page through entries created after the time "since" or after the
+// position "cursor" returned by a previous page, oldest first
+func (s *Server) CreatedEntries(c *gin.Context) {
+	after := createdAfter(time.Time{})
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			c.JSON(400, Error{400, "'since' must be an RFC 3339 timestamp but was '" + since + "'"})
+			return
+		}
+		after = createdAfter(t)
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		var err error
+		if after, err = parseCreatedCursor(cursor); err != nil {
+			c.JSON(400, Error{400, err.Error()})
+			return
+		}
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(DEFAULT_CREATED_PAGE_SIZE)))
+	if err != nil || limit < 1 || limit > MAX_CREATED_PAGE_SIZE {
+		c.JSON(400, Error{400, "'limit' must be between 1 and " + strconv.Itoa(MAX_CREATED_PAGE_SIZE)})
+		return
+	}
+	entries, errors := s.store(c).ListCreated(after, limit)
+	if len(entries) > 0 {
+		after = createdCursorOf(entries[len(entries)-1])
+	}
+	c.JSON(200, CreatedEntriesResponse{errors, entries, after.String()})
+}
+
+// add extra keys resolving to the values of existing keys
+func (s *Server) AddAliases(c *gin.Context) {
+	aliases := []Alias{}
+	if err := c.BindJSON(&aliases); err != nil {
+		c.JSON(400, Error{400, err.Error()})
+		return
+	}
+	entries, errors := s.store(c).AddAliases(aliases)
+	c.JSON(200, RetrieveEntryResponse{errors, entries})
+}
+
+// remove aliases given as a list of keys in the body
+func (s *Server) RemoveAliases(c *gin.Context) {
+	aliases := []string{}
+	if err := c.BindJSON(&aliases); err != nil {
+		c.JSON(400, Error{400, err.Error()})
+		return
+	}
+	entries, errors := s.store(c).RemoveAliases(removeDuplicates(aliases))
+	c.JSON(200, RetrieveEntryResponse{errors, entries})
+}
+
+// largest number of random entries returned at once
 var MAX_N = 25
 
+// Get a specified number of random entries, optionally only those
+// with keys starting with "prefix" and metadata matching "where"
 func (s *Server) RandomEntries(c *gin.Context) {
 	n, err := strconv.Atoi(c.DefaultQuery("n", "1"))
 	if err != nil {
@@ -88,11 +306,23 @@ func (s *Server) RandomEntries(c *gin.Context) {
 		c.JSON(400, Error{400, "'n' must be positive and greater than " + strconv.Itoa(MAX_N)})
 		return
 	}
-	entries, err := readRandomEntries(s.V2k, n)
+	filter := RandomFilter{Prefix: c.Query("prefix")}
+	if where := c.Query("where"); where != "" {
+		if err := json.Unmarshal([]byte(where), &filter.Metadata); err != nil || filter.Metadata == nil {
+			c.JSON(400, Error{400, "'where' must be a JSON object but was '" + where + "'"})
+			return
+		}
+	}
+	entries, err := s.store(c).ReadRandomEntries(n, filter)
 	if err != nil {
 		c.JSON(500, Error{500, err.Error()})
 		return
 	}
+	entries, errs := s.withMetadata(c, entries, nil)
+	if len(errs) > 0 {
+		c.JSON(500, Error{500, errs[0]})
+		return
+	}
 	// success
 	c.JSON(200, entries)
 }
@@ -104,26 +334,168 @@ func (s *Server) GetEntriesFromKeys(c *gin.Context) {
 		return
 	}
 	keys = removeDuplicates(keys)
-	entries, errs := GetEntriesFromKeys(s.K2v, keys)
+	entries, errs := s.store(c).GetEntriesFromKeys(keys)
+	entries, errs = s.withMetadata(c, entries, errs)
 	c.JSON(200, RetrieveEntryResponse{errs, entries})
 }
 
 func (s *Server) GetEntriesFromValues(c *gin.Context) {
-	values := []int{}
+	values := []int64{}
 	if err := c.BindJSON(&values); err != nil {
 		c.JSON(400, Error{400, err.Error()})
 		return
 	}
-	entries, errs := GetEntriesFromValues(s.V2k, values)
+	entries, errs := s.store(c).GetEntriesFromValues(values)
+	entries, errs = s.withMetadata(c, entries, errs)
 	c.JSON(200, RetrieveEntryResponse{errs, entries})
 }
 
+// entries with keys starting with "q", ignoring case with
+// "mode=insensitive", containing "q" with "mode=contains" or within
+// "distance" edits of "q" with "mode=fuzzy". Pages of up to "limit"
+// entries continue after "cursor" returned by the previous page.
 func (s *Server) Search(c *gin.Context) {
 	q := c.Query("q")
 	if q == "" {
 		c.JSON(400, Error{400, "a query must be passed to /search"})
 		return
 	}
-	entries, errs := SeekWithPrefix(s.K2v, q)
-	c.JSON(200, RetrieveEntryResponse{errs, entries})
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(MAX_QUERY_RESULTS)))
+	if err != nil || limit < 1 || limit > MAX_SEARCH_LIMIT {
+		c.JSON(400, Error{400, "'limit' must be between 1 and " + strconv.Itoa(MAX_SEARCH_LIMIT)})
+		return
+	}
+	after := SearchCursor{}
+	if cursor := c.Query("cursor"); cursor != "" {
+		if after, err = parseSearchCursor(cursor); err != nil {
+			c.JSON(400, Error{400, err.Error()})
+			return
+		}
+	}
+	var entries []Entry
+	var errs []string
+	switch mode := c.DefaultQuery("mode", SEARCH_MODE_PREFIX); mode {
+	case SEARCH_MODE_PREFIX:
+		entries, errs = s.store(c).SeekWithPrefix(q, after, limit)
+	case SEARCH_MODE_INSENSITIVE:
+		entries, errs = s.store(c).SeekWithFoldedPrefix(q, after, limit)
+	case SEARCH_MODE_CONTAINS:
+		entries, errs = s.store(c).SearchContains(q, after, limit)
+	case SEARCH_MODE_FUZZY:
+		distance, err := strconv.Atoi(c.DefaultQuery("distance", "1"))
+		if err != nil || distance < 1 || distance > MAX_FUZZY_DISTANCE {
+			c.JSON(400, Error{400, "'distance' must be between 1 and " + strconv.Itoa(MAX_FUZZY_DISTANCE)})
+			return
+		}
+		entries, errs = s.store(c).SearchFuzzy(q, distance, after, limit)
+	default:
+		c.JSON(400, Error{400, "'mode' must be '" + SEARCH_MODE_PREFIX + "', '" + SEARCH_MODE_INSENSITIVE + "', '" + SEARCH_MODE_CONTAINS + "' or '" + SEARCH_MODE_FUZZY + "' but was '" + mode + "'"})
+		return
+	}
+	entries, errs = s.withMetadata(c, entries, errs)
+	resp := SearchResponse{errs, entries, ""}
+	if len(entries) == limit {
+		resp.Cursor = after.next(entries).String()
+	}
+	c.JSON(200, resp)
+}
+
+// number of entries streamed between flushes of the response
+var STREAM_FLUSH_SIZE = 100
+
+// supported values of "order" on GET /entries
+const SCAN_ORDER_KEY = "key"
+const SCAN_ORDER_VALUE = "value"
+
+// streams entries with keys between "start" and "end" in key order, or
+// with "order=value" entries with values between "min" and "max" in
+// numeric order. Up to "limit" entries are returned, or all if no limit
+// is given, and "cursor" continues after the last entry of a previous
+// response.
+func (s *Server) ScanEntries(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 {
+		c.JSON(400, Error{400, "'limit' must be a non-negative int"})
+		return
+	}
+	after := SearchCursor{}
+	cursor := c.Query("cursor")
+	if cursor != "" {
+		if after, err = parseSearchCursor(cursor); err != nil {
+			c.JSON(400, Error{400, err.Error()})
+			return
+		}
+	}
+	switch order := c.DefaultQuery("order", SCAN_ORDER_KEY); order {
+	case SCAN_ORDER_KEY:
+		r := KeyRange{c.Query("start"), c.Query("end")}
+		if r.End != "" && r.End <= r.Start {
+			c.JSON(400, Error{400, "'end' must be after 'start'"})
+			return
+		}
+		streamEntries(c, limit, func(f func(Entry) error) error {
+			return s.store(c).ScanKeys(r, after, limit, f)
+		})
+	case SCAN_ORDER_VALUE:
+		r := ValueRange{MIN_VALUE, MAX_VALUE}
+		for _, bound := range []struct {
+			param string
+			v     *int64
+		}{{"min", &r.Min}, {"max", &r.Max}} {
+			if p := c.Query(bound.param); p != "" {
+				if *bound.v, err = strconv.ParseInt(p, 10, 64); err != nil {
+					c.JSON(400, Error{400, "'" + bound.param + "' must be an int but was '" + p + "'"})
+					return
+				}
+			}
+		}
+		if r.Max < r.Min {
+			c.JSON(400, Error{400, "'max' must be at least 'min'"})
+			return
+		}
+		streamEntries(c, limit, func(f func(Entry) error) error {
+			return s.store(c).ScanValues(r, after, limit, f)
+		})
+	default:
+		c.JSON(400, Error{400, "'order' must be '" + SCAN_ORDER_KEY + "' or '" + SCAN_ORDER_VALUE + "' but was '" + order + "'"})
+	}
+}
+
+// writes the entries passed to f by scan as a SearchResponse, flushing
+// every STREAM_FLUSH_SIZE entries. Errors are written after the entries,
+// and a cursor after the last entry if limit entries were written.
+func streamEntries(c *gin.Context, limit int, scan func(f func(Entry) error) error) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(200)
+	w := c.Writer
+	enc := json.NewEncoder(w)
+	last := Entry{}
+	n := 0
+	w.WriteString(`{"entries":[`)
+	err := scan(func(e Entry) error {
+		if n > 0 {
+			w.WriteString(",")
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		n++
+		if n%STREAM_FLUSH_SIZE == 0 {
+			w.Flush()
+		}
+		last = e
+		return nil
+	})
+	errors := []string{}
+	if err != nil {
+		logErr("Error streaming entries: %v", err)
+		errors = append(errors, err.Error())
+	}
+	w.WriteString(`],"errors":`)
+	enc.Encode(errors)
+	if limit > 0 && n == limit {
+		w.WriteString(`,"cursor":`)
+		enc.Encode(SearchCursor{Key: last.Key, Value: last.Value}.String())
+	}
+	w.WriteString("}")
 }
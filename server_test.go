@@ -13,13 +13,14 @@ import (
 	"os"
 	"strconv"
 	"testing"
+	"time"
 )
 
 var testingDir = "/tmp/twowaykv/temp"
 
 func TestRemoveDupliactes(t *testing.T) {
 
-	loadPath := "/tmp/twowaykv/randomEntries/" + strconv.Itoa(rand.Intn(INT_MAX))
+	loadPath := "/tmp/twowaykv/randomEntries/" + strconv.Itoa(rand.Int())
 	err := os.MkdirAll(loadPath, os.ModePerm)
 	require.NoError(t, err)
 	defer os.RemoveAll(loadPath)
@@ -68,23 +69,23 @@ func TestRemoveDupliactes(t *testing.T) {
 
 func TestRandomEntries(t *testing.T) {
 
-	loadPath := "/tmp/twowaykv/randomEntries/" + strconv.Itoa(rand.Intn(INT_MAX))
+	loadPath := "/tmp/twowaykv/randomEntries/" + strconv.Itoa(rand.Int())
 	err := os.MkdirAll(loadPath, os.ModePerm)
 	require.NoError(t, err)
 	defer os.RemoveAll(loadPath)
 	os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
-	router, s := SetupRouter("./api/*")
+	router, server := SetupRouter("./api/*")
+	s := server.Store.(*BadgerStore)
 
 	// insert some randm stuff into db
-	err = s.V2k.Update(func(txn *badger.Txn) error {
-		for i := 0; i < 10; i++ {
-			if e := txn.Set([]byte(strconv.Itoa(i+2)), []byte("TEST-KEY")); e != nil {
-				return e
-			}
-		}
-		return nil
-	})
-	require.Nil(t, err)
+	values := []int64{}
+	entries := []Entry{}
+	for i := 0; i < 10; i++ {
+		values = append(values, int64(i+2))
+		entries = append(entries, Entry{Key: fmt.Sprintf("TEST-KEY-%d", i), Value: int64(i + 2)})
+	}
+	_, errors := s.ImportEntries(entries)
+	require.Equal(t, []string{}, errors)
 
 	type Test struct {
 		Name                  string
@@ -127,24 +128,15 @@ func TestRandomEntries(t *testing.T) {
 			Method:                "GET",
 		},
 		Test{
-			Name: "returns error from db call",
+			Name: "returns no entries from empty db",
 			Path: "/random",
 			Before: func() {
-				// insert some randm stuff into db
-				err = s.V2k.Update(func(txn *badger.Txn) error {
-					for i := 0; i < 10; i++ {
-						if e := txn.Delete([]byte(strconv.Itoa(i + 2))); e != nil {
-							return e
-						}
-					}
-					return nil
-				})
-				require.Nil(t, err)
-
+				_, errors := s.DeleteEntries([]string{}, values)
+				require.Equal(t, 0, len(errors))
 			},
-			ExpectedCode:          500,
-			ExpectedError:         "max collisions reached finding random entries",
-			ExpectedEntriesLength: 1,
+			ExpectedCode:          200,
+			ExpectedError:         "",
+			ExpectedEntriesLength: 0,
 			Method:                "GET",
 		},
 	}
@@ -167,8 +159,8 @@ func TestRandomEntries(t *testing.T) {
 				assert.Equal(t, test.ExpectedEntriesLength, len(resp))
 				// set createdEntry on success
 				if len(resp) > 0 {
-					assert.NotEqual(t, 0, resp[0].Value)
-					validTestValue = strconv.Itoa(resp[0].Value)
+					assert.NotEqual(t, int64(0), resp[0].Value)
+					validTestValue = strconv.FormatInt(resp[0].Value, 10)
 					assert.NotEqual(t, "0", validTestValue)
 				}
 			} else {
@@ -185,7 +177,7 @@ func TestRandomEntries(t *testing.T) {
 }
 
 func TestCreateEntriesEntry(t *testing.T) {
-	loadPath := "/tmp/twowaykv/retrieveEntry/" + strconv.Itoa(rand.Intn(INT_MAX))
+	loadPath := "/tmp/twowaykv/retrieveEntry/" + strconv.Itoa(rand.Int())
 	err := os.MkdirAll(loadPath, os.ModePerm)
 	require.NoError(t, err)
 	defer os.RemoveAll(loadPath)
@@ -242,6 +234,24 @@ func TestCreateEntriesEntry(t *testing.T) {
 			ExpectedErrors:        []string{"json: cannot unmarshal number into Go value of type []string"},
 			Method:                "POST",
 		},
+		Test{
+			Name:                  "creates entries with a ttl",
+			Path:                  "/entries?ttl=1h",
+			Body:                  []byte(`["temporaryKey"]`),
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 1,
+			ExpectedErrors:        []string{},
+			Method:                "POST",
+		},
+		Test{
+			Name:                  "rejects invalid ttls",
+			Path:                  "/entries?ttl=10ms",
+			Body:                  []byte(`["temporaryKey"]`),
+			ExpectedCode:          400,
+			ExpectedEntriesLength: 0,
+			ExpectedErrors:        []string{"'ttl' must be a duration of at least 1s but was '10ms'"},
+			Method:                "POST",
+		},
 		Test{
 			Name:                  "Creates many entries succesfully",
 			Path:                  "/entries",
@@ -274,8 +284,8 @@ func TestCreateEntriesEntry(t *testing.T) {
 				assert.Equal(t, test.ExpectedErrors, resp.Errors)
 				// set createdEntry on success
 				if len(resp.Entries) > 0 {
-					assert.NotEqual(t, 0, resp.Entries[0].Value)
-					validTestValue = strconv.Itoa(resp.Entries[0].Value)
+					assert.NotEqual(t, int64(0), resp.Entries[0].Value)
+					validTestValue = strconv.FormatInt(resp.Entries[0].Value, 10)
 					assert.NotEqual(t, "0", validTestValue)
 				}
 			} else {
@@ -292,12 +302,13 @@ func TestCreateEntriesEntry(t *testing.T) {
 
 // tests both "/entriesFromKeys" and "/entriesFromValues"
 func TestGetEntries(t *testing.T) {
-	loadPath := "/tmp/twowaykv/api/" + strconv.Itoa(rand.Intn(INT_MAX))
+	loadPath := "/tmp/twowaykv/api/" + strconv.Itoa(rand.Int())
 	err := os.MkdirAll(loadPath, os.ModePerm)
 	require.NoError(t, err)
 	defer os.RemoveAll(loadPath)
 	os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
-	router, s := SetupRouter("./api/*")
+	router, server := SetupRouter("./api/*")
+	s := server.Store.(*BadgerStore)
 
 	type Test struct {
 		Name                  string
@@ -321,7 +332,7 @@ func TestGetEntries(t *testing.T) {
 			Method:                "POST",
 			Setup: func() {
 				err := s.K2v.Update(func(txn *badger.Txn) error {
-					if e := txn.Set([]byte("testKey"), []byte("111")); e != nil {
+					if e := txn.Set([]byte("testKey"), encodeValue(111)); e != nil {
 						return e
 					}
 					return nil
@@ -349,7 +360,7 @@ func TestGetEntries(t *testing.T) {
 			Method:                "POST",
 			Setup: func() {
 				err := s.K2v.Update(func(txn *badger.Txn) error {
-					if e := txn.Set([]byte("testKey"), []byte("111")); e != nil {
+					if e := txn.Set([]byte("testKey"), encodeValue(111)); e != nil {
 						return e
 					}
 					return nil
@@ -378,7 +389,7 @@ func TestGetEntries(t *testing.T) {
 			Method:                "POST",
 			Setup: func() {
 				err := s.V2k.Update(func(txn *badger.Txn) error {
-					if e := txn.Set([]byte("115"), []byte("testKey115")); e != nil {
+					if e := txn.Set(encodeValue(115), []byte("testKey115")); e != nil {
 						return e
 					}
 					return nil
@@ -388,7 +399,7 @@ func TestGetEntries(t *testing.T) {
 			},
 			TearDown: func() {
 				err := s.V2k.Update(func(txn *badger.Txn) error {
-					if e := txn.Delete([]byte("115")); e != nil {
+					if e := txn.Delete(encodeValue(115)); e != nil {
 						return e
 					}
 					return nil
@@ -407,7 +418,7 @@ func TestGetEntries(t *testing.T) {
 			Method:                "POST",
 			Setup: func() {
 				err := s.K2v.Update(func(txn *badger.Txn) error {
-					if e := txn.Set([]byte("testKey"), []byte("111")); e != nil {
+					if e := txn.Set([]byte("testKey"), encodeValue(111)); e != nil {
 						return e
 					}
 					return nil
@@ -463,17 +474,18 @@ func TestGetEntries(t *testing.T) {
 
 func TestSearch(t *testing.T) {
 
-	loadPath := "/tmp/twowaykv/randomEntries/" + strconv.Itoa(rand.Intn(INT_MAX))
+	loadPath := "/tmp/twowaykv/randomEntries/" + strconv.Itoa(rand.Int())
 	err := os.MkdirAll(loadPath, os.ModePerm)
 	require.NoError(t, err)
 	defer os.RemoveAll(loadPath)
 	os.Setenv("GRAPH_DB_STORE_DIR", loadPath)
-	router, s := SetupRouter("./api/*")
+	router, server := SetupRouter("./api/*")
+	s := server.Store.(*BadgerStore)
 
 	// insert some randm stuff into db
 	err = s.K2v.Update(func(txn *badger.Txn) error {
 		for i := 0; i < 10; i++ {
-			if e := txn.Set([]byte("TEST-KEY-"+strconv.Itoa(i)), []byte("1")); e != nil {
+			if e := txn.Set([]byte("TEST-KEY-"+strconv.Itoa(i)), encodeValue(1)); e != nil {
 				return e
 			}
 		}
@@ -500,6 +512,66 @@ func TestSearch(t *testing.T) {
 			ExpectedEntriesLength: 10,
 			Method:                "GET",
 		},
+		Test{
+			Name: "finds keys ignoring case",
+			Path: "/search?q=test-key-&mode=insensitive",
+			Before: func() {
+				_, errors := s.CreateIfDoesntExist([]string{"test-key-a", "Test-Key-B"}, false)
+				require.Equal(t, []string{}, errors)
+			},
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 2,
+			Method:                "GET",
+		},
+		Test{
+			Name:                  "reports substring search without trigram index",
+			Path:                  "/search?q=key&mode=contains",
+			Before:                func() {},
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 0,
+			ExpectedErrorsLength:  1,
+			Method:                "GET",
+		},
+		Test{
+			Name:                 "returns error on too large fuzzy distance",
+			Path:                 "/search?q=key&mode=fuzzy&distance=5",
+			Before:               func() {},
+			ExpectedCode:         400,
+			ExpectedErrorsLength: 1,
+			Method:               "GET",
+		},
+		Test{
+			Name:                  "returns a page of up to limit keys",
+			Path:                  "/search?q=TES&limit=3",
+			Before:                func() {},
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 3,
+			Method:                "GET",
+		},
+		Test{
+			Name:                 "returns error on too large limit",
+			Path:                 "/search?q=TES&limit=100000",
+			Before:               func() {},
+			ExpectedCode:         400,
+			ExpectedErrorsLength: 1,
+			Method:               "GET",
+		},
+		Test{
+			Name:                 "returns error on invalid cursor",
+			Path:                 "/search?q=TES&cursor=abc",
+			Before:               func() {},
+			ExpectedCode:         400,
+			ExpectedErrorsLength: 1,
+			Method:               "GET",
+		},
+		Test{
+			Name:                 "returns error on unknown mode",
+			Path:                 "/search?q=TES&mode=regex",
+			Before:               func() {},
+			ExpectedCode:         400,
+			ExpectedErrorsLength: 1,
+			Method:               "GET",
+		},
 		Test{
 			Name:                 "returns error if no query is passed",
 			Path:                 "/search",
@@ -536,4 +608,670 @@ func TestSearch(t *testing.T) {
 
 	}
 
+	t.Run("walks all keys under a prefix with cursors", func(t *testing.T) {
+		keys := []string{}
+		path := "/search?q=TEST-KEY-&limit=4"
+		for i := 0; i < 10; i++ {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			router.ServeHTTP(w, req)
+			require.Equal(t, 200, w.Code)
+			resp := SearchResponse{}
+			require.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			for _, e := range resp.Entries {
+				keys = append(keys, e.Key)
+			}
+			if resp.Cursor == "" {
+				break
+			}
+			path = "/search?q=TEST-KEY-&limit=4&cursor=" + resp.Cursor
+		}
+		assert.Equal(t, 10, len(keys))
+		assert.Equal(t, "TEST-KEY-0", keys[0])
+		assert.Equal(t, "TEST-KEY-9", keys[9])
+	})
+
+}
+
+func TestMemoryStoreRouter(t *testing.T) {
+	os.Setenv("GRAPH_DB_STORE_TYPE", "memory")
+	defer os.Unsetenv("GRAPH_DB_STORE_TYPE")
+	router, s := SetupRouter("./api/*")
+	_, ok := s.Store.(*MemoryStore)
+	require.True(t, ok)
+
+	// create entries
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/entries", bytes.NewBuffer([]byte(`["memKey1", "memKey2", "memKey2"]`)))
+	req.Header.Add("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	created := RetrieveEntryResponse{}
+	require.Nil(t, json.Unmarshal([]byte(w.Body.String()), &created))
+	require.Equal(t, 2, len(created.Entries))
+
+	// look them back up by value
+	w = httptest.NewRecorder()
+	body := fmt.Sprintf(`[%d, %d]`, created.Entries[0].Value, created.Entries[1].Value)
+	req, _ = http.NewRequest("POST", "/entriesFromValues", bytes.NewBuffer([]byte(body)))
+	req.Header.Add("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	found := RetrieveEntryResponse{}
+	require.Nil(t, json.Unmarshal([]byte(w.Body.String()), &found))
+	assert.Equal(t, created.Entries, found.Entries)
+	assert.Equal(t, 0, len(found.Errors))
+}
+
+func TestDeleteEntriesEndpoint(t *testing.T) {
+	os.Setenv("GRAPH_DB_STORE_TYPE", "memory")
+	defer os.Unsetenv("GRAPH_DB_STORE_TYPE")
+	router, s := SetupRouter("./api/*")
+	created, _ := s.Store.CreateIfDoesntExist([]string{"del1", "del2", "del3"}, false)
+
+	type Test struct {
+		Name                  string
+		Path                  string
+		Method                string
+		Body                  []byte
+		ExpectedCode          int
+		ExpectedEntriesLength int
+		ExpectedErrorsLength  int
+	}
+	testTable := []Test{
+		Test{
+			Name:                  "deletes by key",
+			Path:                  "/entries",
+			Method:                "DELETE",
+			Body:                  []byte(`{"keys": ["del1", "del1"]}`),
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 1,
+			ExpectedErrorsLength:  0,
+		},
+		Test{
+			Name:                  "deletes by value in batch form",
+			Path:                  "/entries/delete",
+			Method:                "POST",
+			Body:                  []byte(fmt.Sprintf(`{"values": [%d, %d]}`, created[1].Value, created[2].Value)),
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 2,
+			ExpectedErrorsLength:  0,
+		},
+		Test{
+			Name:                  "returns errors for missing entries",
+			Path:                  "/entries",
+			Method:                "DELETE",
+			Body:                  []byte(`{"keys": ["del1"], "values": [1]}`),
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 0,
+			ExpectedErrorsLength:  2,
+		},
+		Test{
+			Name:         "returns error for bad json",
+			Path:         "/entries",
+			Method:       "DELETE",
+			Body:         []byte(`["del1"]`),
+			ExpectedCode: 400,
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(test.Method, test.Path, bytes.NewBuffer(test.Body))
+			req.Header.Add("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			assert.Equal(t, test.ExpectedCode, w.Code)
+			body := []byte(w.Body.String())
+			if test.ExpectedCode == 200 {
+				resp := RetrieveEntryResponse{}
+				err := json.Unmarshal(body, &resp)
+				assert.Nil(t, err)
+				assert.Equal(t, test.ExpectedEntriesLength, len(resp.Entries))
+				assert.Equal(t, test.ExpectedErrorsLength, len(resp.Errors))
+			} else {
+				resp := Error{}
+				err := json.Unmarshal(body, &resp)
+				require.Nil(t, err)
+				assert.Equal(t, test.ExpectedCode, resp.Code)
+				assert.NotEqual(t, "", resp.Error)
+			}
+		})
+	}
+}
+
+func TestRenameEntriesEndpoint(t *testing.T) {
+	os.Setenv("GRAPH_DB_STORE_TYPE", "memory")
+	defer os.Unsetenv("GRAPH_DB_STORE_TYPE")
+	router, s := SetupRouter("./api/*")
+	s.Store.CreateIfDoesntExist([]string{"old1", "old2", "old3"}, false)
+
+	type Test struct {
+		Name                  string
+		Path                  string
+		Body                  []byte
+		ExpectedCode          int
+		ExpectedEntriesLength int
+		ExpectedErrorsLength  int
+	}
+	testTable := []Test{
+		Test{
+			Name:                  "renames from query params",
+			Path:                  "/entries/rename?from=old1&to=new1",
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 1,
+			ExpectedErrorsLength:  0,
+		},
+		Test{
+			Name:                  "renames in batch form",
+			Path:                  "/entries/rename",
+			Body:                  []byte(`[{"from": "old2", "to": "new2"}, {"from": "old3", "to": "new1"}]`),
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 1,
+			ExpectedErrorsLength:  1,
+		},
+		Test{
+			Name:                  "rejects keys which aren't valid UTF-8",
+			Path:                  "/entries/rename?from=new1&to=%FFns/x/k/foo",
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 0,
+			ExpectedErrorsLength:  1,
+		},
+		Test{
+			Name:         "returns error for missing 'to'",
+			Path:         "/entries/rename?from=new1",
+			ExpectedCode: 400,
+		},
+		Test{
+			Name:         "returns error for bad json",
+			Path:         "/entries/rename",
+			Body:         []byte(`{"from": "old3"}`),
+			ExpectedCode: 400,
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", test.Path, bytes.NewBuffer(test.Body))
+			req.Header.Add("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			assert.Equal(t, test.ExpectedCode, w.Code)
+			body := []byte(w.Body.String())
+			if test.ExpectedCode == 200 {
+				resp := RetrieveEntryResponse{}
+				err := json.Unmarshal(body, &resp)
+				assert.Nil(t, err)
+				assert.Equal(t, test.ExpectedEntriesLength, len(resp.Entries))
+				assert.Equal(t, test.ExpectedErrorsLength, len(resp.Errors))
+			} else {
+				resp := Error{}
+				err := json.Unmarshal(body, &resp)
+				require.Nil(t, err)
+				assert.Equal(t, test.ExpectedCode, resp.Code)
+				assert.NotEqual(t, "", resp.Error)
+			}
+		})
+	}
+}
+
+func TestImportEntriesEndpoint(t *testing.T) {
+	os.Setenv("GRAPH_DB_STORE_TYPE", "memory")
+	defer os.Unsetenv("GRAPH_DB_STORE_TYPE")
+	router, _ := SetupRouter("./api/*")
+
+	type Test struct {
+		Name                  string
+		Body                  []byte
+		ExpectedCode          int
+		ExpectedEntriesLength int
+		ExpectedErrorsLength  int
+	}
+	testTable := []Test{
+		Test{
+			Name:                  "imports entries",
+			Body:                  []byte(`[{"key": "imp1", "value": 10}, {"key": "imp2", "value": 20}]`),
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 2,
+			ExpectedErrorsLength:  0,
+		},
+		Test{
+			Name:                  "reports conflicts",
+			Body:                  []byte(`[{"key": "imp1", "value": 30}, {"key": "imp3", "value": 30}]`),
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 1,
+			ExpectedErrorsLength:  1,
+		},
+		Test{
+			Name:         "returns error for bad json",
+			Body:         []byte(`["imp4"]`),
+			ExpectedCode: 400,
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/entries/import", bytes.NewBuffer(test.Body))
+			req.Header.Add("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			assert.Equal(t, test.ExpectedCode, w.Code)
+			body := []byte(w.Body.String())
+			if test.ExpectedCode == 200 {
+				resp := RetrieveEntryResponse{}
+				err := json.Unmarshal(body, &resp)
+				assert.Nil(t, err)
+				assert.Equal(t, test.ExpectedEntriesLength, len(resp.Entries))
+				assert.Equal(t, test.ExpectedErrorsLength, len(resp.Errors))
+			} else {
+				resp := Error{}
+				err := json.Unmarshal(body, &resp)
+				require.Nil(t, err)
+				assert.Equal(t, test.ExpectedCode, resp.Code)
+			}
+		})
+	}
+}
+
+func TestAliasesEndpoints(t *testing.T) {
+	os.Setenv("GRAPH_DB_STORE_TYPE", "memory")
+	defer os.Unsetenv("GRAPH_DB_STORE_TYPE")
+	router, s := SetupRouter("./api/*")
+	s.Store.CreateIfDoesntExist([]string{"Earth"}, false)
+
+	type Test struct {
+		Name                  string
+		Path                  string
+		Method                string
+		Body                  []byte
+		ExpectedCode          int
+		ExpectedEntriesLength int
+		ExpectedErrorsLength  int
+	}
+	testTable := []Test{
+		Test{
+			Name:                  "adds aliases",
+			Path:                  "/aliases",
+			Method:                "POST",
+			Body:                  []byte(`[{"alias": "Terra", "key": "Earth"}, {"alias": "Gaia", "key": "Earth"}, {"alias": "x", "key": "Venus"}]`),
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 2,
+			ExpectedErrorsLength:  1,
+		},
+		Test{
+			Name:                  "removes aliases",
+			Path:                  "/aliases",
+			Method:                "DELETE",
+			Body:                  []byte(`["Terra", "Earth"]`),
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 1,
+			ExpectedErrorsLength:  1,
+		},
+		Test{
+			Name:                  "removes aliases in POST form",
+			Path:                  "/aliases/delete",
+			Method:                "POST",
+			Body:                  []byte(`["Gaia"]`),
+			ExpectedCode:          200,
+			ExpectedEntriesLength: 1,
+			ExpectedErrorsLength:  0,
+		},
+		Test{
+			Name:         "returns error for bad json",
+			Path:         "/aliases",
+			Method:       "POST",
+			Body:         []byte(`["Terra"]`),
+			ExpectedCode: 400,
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(test.Method, test.Path, bytes.NewBuffer(test.Body))
+			req.Header.Add("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			assert.Equal(t, test.ExpectedCode, w.Code)
+			body := []byte(w.Body.String())
+			if test.ExpectedCode == 200 {
+				resp := RetrieveEntryResponse{}
+				err := json.Unmarshal(body, &resp)
+				assert.Nil(t, err)
+				assert.Equal(t, test.ExpectedEntriesLength, len(resp.Entries))
+				assert.Equal(t, test.ExpectedErrorsLength, len(resp.Errors))
+			} else {
+				resp := Error{}
+				err := json.Unmarshal(body, &resp)
+				require.Nil(t, err)
+				assert.Equal(t, test.ExpectedCode, resp.Code)
+			}
+		})
+	}
+}
+
+func TestNamespaceEndpoints(t *testing.T) {
+	os.Setenv("GRAPH_DB_STORE_TYPE", "memory")
+	defer os.Unsetenv("GRAPH_DB_STORE_TYPE")
+	os.Setenv("GRAPH_DB_ID_ALLOCATION", ALLOCATION_SEQUENTIAL)
+	defer os.Unsetenv("GRAPH_DB_ID_ALLOCATION")
+	router, _ := SetupRouter("./api/*")
+
+	type Test struct {
+		Name             string
+		Path             string
+		Method           string
+		Body             []byte
+		ExpectedCode     int
+		ExpectedResponse string
+	}
+	testTable := []Test{
+		Test{
+			Name:             "creates namespaces",
+			Path:             "/namespaces",
+			Method:           "POST",
+			Body:             []byte(`["users", "articles", "users", "a b"]`),
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":["Namespace name must be 1 to 64 letters, digits, '-' or '_' but was 'a b'"],"namespaces":["users","articles"]}`,
+		},
+		Test{
+			Name:             "lists namespaces",
+			Path:             "/namespaces",
+			Method:           "GET",
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"namespaces":["articles","users"]}`,
+		},
+		Test{
+			Name:             "creates entries in a namespace",
+			Path:             "/ns/users/entries",
+			Method:           "POST",
+			Body:             []byte(`["alice"]`),
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[{"key":"alice","value":1}]}`,
+		},
+		Test{
+			Name:             "keeps namespaces apart",
+			Path:             "/ns/articles/entriesFromKeys",
+			Method:           "POST",
+			Body:             []byte(`["alice"]`),
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":["Could not retrieve entry from key alice: Key not found"],"entries":null}`,
+		},
+		Test{
+			Name:             "keeps the default store apart",
+			Path:             "/entriesFromKeys",
+			Method:           "POST",
+			Body:             []byte(`["alice"]`),
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":["Could not retrieve entry from key alice: Key not found"],"entries":null}`,
+		},
+		Test{
+			Name:             "returns 404 for missing namespaces",
+			Path:             "/ns/categories/entries",
+			Method:           "POST",
+			Body:             []byte(`["alice"]`),
+			ExpectedCode:     404,
+			ExpectedResponse: `{"Code":404,"Error":"Namespace categories not found"}`,
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(test.Method, test.Path, bytes.NewBuffer(test.Body))
+			req.Header.Add("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			assert.Equal(t, test.ExpectedCode, w.Code)
+			assert.Equal(t, test.ExpectedResponse, w.Body.String())
+		})
+	}
+}
+
+func TestMetadataEndpoints(t *testing.T) {
+	os.Setenv("GRAPH_DB_STORE_TYPE", "memory")
+	defer os.Unsetenv("GRAPH_DB_STORE_TYPE")
+	router, s := SetupRouter("./api/*")
+	s.Store.ImportEntries([]Entry{Entry{Key: "a", Value: 1}})
+
+	type Test struct {
+		Name             string
+		Path             string
+		Method           string
+		Body             []byte
+		ExpectedCode     int
+		ExpectedResponse string
+	}
+	testTable := []Test{
+		Test{
+			Name:             "updates metadata",
+			Path:             "/entries/metadata",
+			Method:           "POST",
+			Body:             []byte(`[{"key": "a", "metadata": {"source": "legacy"}}]`),
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[{"key":"a","value":1,"metadata":{"source":"legacy"}}]}`,
+		},
+		Test{
+			Name:             "leaves out metadata by default",
+			Path:             "/entriesFromKeys",
+			Method:           "POST",
+			Body:             []byte(`["a"]`),
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":null,"entries":[{"key":"a","value":1}]}`,
+		},
+		Test{
+			Name:             "returns metadata when requested",
+			Path:             "/entriesFromValues?metadata=true",
+			Method:           "POST",
+			Body:             []byte(`[1]`),
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":null,"entries":[{"key":"a","value":1,"metadata":{"source":"legacy"}}]}`,
+		},
+		Test{
+			Name:             "returns metadata from search",
+			Path:             "/search?q=a&metadata=true",
+			Method:           "GET",
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":null,"entries":[{"key":"a","value":1,"metadata":{"source":"legacy"}}]}`,
+		},
+		Test{
+			Name:             "returns metadata from random",
+			Path:             "/random?metadata=true",
+			Method:           "GET",
+			ExpectedCode:     200,
+			ExpectedResponse: `[{"key":"a","value":1,"metadata":{"source":"legacy"}}]`,
+		},
+		Test{
+			Name:             "samples random entries by metadata",
+			Path:             "/random?n=5&prefix=a&where=%7B%22source%22%3A%22legacy%22%7D",
+			Method:           "GET",
+			ExpectedCode:     200,
+			ExpectedResponse: `[{"key":"a","value":1}]`,
+		},
+		Test{
+			Name:             "samples no entries without matching metadata",
+			Path:             "/random?where=%7B%22source%22%3A%22new%22%7D",
+			Method:           "GET",
+			ExpectedCode:     200,
+			ExpectedResponse: `[]`,
+		},
+		Test{
+			Name:             "rejects metadata filters which aren't objects",
+			Path:             "/random?where=legacy",
+			Method:           "GET",
+			ExpectedCode:     400,
+			ExpectedResponse: `{"Code":400,"Error":"'where' must be a JSON object but was 'legacy'"}`,
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(test.Method, test.Path, bytes.NewBuffer(test.Body))
+			req.Header.Add("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			assert.Equal(t, test.ExpectedCode, w.Code)
+			assert.Equal(t, test.ExpectedResponse, w.Body.String())
+		})
+	}
+}
+
+func TestCreatedEntriesEndpoint(t *testing.T) {
+	os.Setenv("GRAPH_DB_STORE_TYPE", "memory")
+	defer os.Unsetenv("GRAPH_DB_STORE_TYPE")
+	router, s := SetupRouter("./api/*")
+	clock := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	defer func() { now = time.Now }()
+	s.Store.ImportEntries([]Entry{Entry{Key: "a", Value: 1}, Entry{Key: "b", Value: 2}, Entry{Key: "c", Value: 3}})
+	cursor := CreatedCursor{time.Date(2019, 6, 1, 0, 0, 2, 0, time.UTC), 2}.String()
+
+	type Test struct {
+		Name             string
+		Path             string
+		ExpectedCode     int
+		ExpectedResponse string
+	}
+	testTable := []Test{
+		Test{
+			Name:             "lists first page",
+			Path:             "/entries/created?limit=2",
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[{"key":"a","value":1,"created":"2019-06-01T00:00:01Z"},{"key":"b","value":2,"created":"2019-06-01T00:00:02Z"}],"cursor":"` + cursor + `"}`,
+		},
+		Test{
+			Name:             "continues from cursor",
+			Path:             "/entries/created?cursor=" + cursor,
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[{"key":"c","value":3,"created":"2019-06-01T00:00:03Z"}],"cursor":"` + CreatedCursor{time.Date(2019, 6, 1, 0, 0, 3, 0, time.UTC), 3}.String() + `"}`,
+		},
+		Test{
+			Name:             "lists since a time",
+			Path:             "/entries/created?since=2019-06-01T00:00:02Z",
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[{"key":"c","value":3,"created":"2019-06-01T00:00:03Z"}],"cursor":"` + CreatedCursor{time.Date(2019, 6, 1, 0, 0, 3, 0, time.UTC), 3}.String() + `"}`,
+		},
+		Test{
+			Name:             "rejects bad timestamps",
+			Path:             "/entries/created?since=yesterday",
+			ExpectedCode:     400,
+			ExpectedResponse: `{"Code":400,"Error":"'since' must be an RFC 3339 timestamp but was 'yesterday'"}`,
+		},
+		Test{
+			Name:             "rejects bad limits",
+			Path:             "/entries/created?limit=0",
+			ExpectedCode:     400,
+			ExpectedResponse: `{"Code":400,"Error":"'limit' must be between 1 and 1000"}`,
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", test.Path, nil)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, test.ExpectedCode, w.Code)
+			assert.Equal(t, test.ExpectedResponse, w.Body.String())
+		})
+	}
+}
+
+func TestScanEntriesEndpoint(t *testing.T) {
+	os.Setenv("GRAPH_DB_STORE_TYPE", "memory")
+	defer os.Unsetenv("GRAPH_DB_STORE_TYPE")
+	router, s := SetupRouter("./api/*")
+	s.Store.ImportEntries([]Entry{Entry{Key: "a", Value: 1}, Entry{Key: "b", Value: 2}, Entry{Key: "c", Value: 3}})
+	cursor := SearchCursor{Key: "b", Value: 2}.String()
+
+	type Test struct {
+		Name             string
+		Path             string
+		ExpectedCode     int
+		ExpectedResponse string
+	}
+	testTable := []Test{
+		Test{
+			Name:             "streams all entries",
+			Path:             "/entries",
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[{"key":"a","value":1},{"key":"b","value":2},{"key":"c","value":3}]}`,
+		},
+		Test{
+			Name:             "streams entries between start and end",
+			Path:             "/entries?start=b&end=c",
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[{"key":"b","value":2}]}`,
+		},
+		Test{
+			Name:             "returns a cursor when the limit is reached",
+			Path:             "/entries?limit=2",
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[{"key":"a","value":1},{"key":"b","value":2}],"cursor":"` + cursor + `"}`,
+		},
+		Test{
+			Name:             "continues from cursor",
+			Path:             "/entries?limit=2&cursor=" + cursor,
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[{"key":"c","value":3}]}`,
+		},
+		Test{
+			Name:             "streams entries with values between min and max",
+			Path:             "/entries?order=value&min=2&max=3",
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[{"key":"b","value":2},{"key":"c","value":3}]}`,
+		},
+		Test{
+			Name:             "continues from cursor in value order",
+			Path:             "/entries?order=value&limit=2&cursor=" + cursor,
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[{"key":"c","value":3}]}`,
+		},
+		Test{
+			Name:             "returns nothing after a cursor at max",
+			Path:             "/entries?order=value&max=2&cursor=" + cursor,
+			ExpectedCode:     200,
+			ExpectedResponse: `{"errors":[],"entries":[]}`,
+		},
+		Test{
+			Name:             "rejects inverted value ranges",
+			Path:             "/entries?order=value&min=3&max=2",
+			ExpectedCode:     400,
+			ExpectedResponse: `{"Code":400,"Error":"'max' must be at least 'min'"}`,
+		},
+		Test{
+			Name:             "rejects bad values",
+			Path:             "/entries?order=value&min=one",
+			ExpectedCode:     400,
+			ExpectedResponse: `{"Code":400,"Error":"'min' must be an int but was 'one'"}`,
+		},
+		Test{
+			Name:             "rejects unknown orders",
+			Path:             "/entries?order=random",
+			ExpectedCode:     400,
+			ExpectedResponse: `{"Code":400,"Error":"'order' must be 'key' or 'value' but was 'random'"}`,
+		},
+		Test{
+			Name:             "rejects inverted ranges",
+			Path:             "/entries?start=c&end=a",
+			ExpectedCode:     400,
+			ExpectedResponse: `{"Code":400,"Error":"'end' must be after 'start'"}`,
+		},
+		Test{
+			Name:             "rejects bad limits",
+			Path:             "/entries?limit=-1",
+			ExpectedCode:     400,
+			ExpectedResponse: `{"Code":400,"Error":"'limit' must be a non-negative int"}`,
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", test.Path, nil)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, test.ExpectedCode, w.Code)
+			assert.JSONEq(t, test.ExpectedResponse, w.Body.String())
+		})
+	}
 }